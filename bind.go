@@ -0,0 +1,149 @@
+package muxy
+
+import (
+	"encoding"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// BindError records the errors found converting route variables in Bind.
+type BindError struct {
+	// Errors holds one error for each variable that failed to convert.
+	Errors []*VarError
+}
+
+func (e *BindError) Error() string {
+	s := make([]string, len(e.Errors))
+	for i, err := range e.Errors {
+		s[i] = err.Error()
+	}
+	return strings.Join(s, "; ")
+}
+
+// Bind sets the fields of the struct pointed to by dst from the route
+// variables in the request context. Fields are matched by their "muxy" tag:
+//
+//     var params struct {
+//         ID   int       `muxy:"id"`
+//         Slug string    `muxy:"slug"`
+//         Day  time.Time `muxy:"day,layout=2006-01-02"`
+//     }
+//     if err := muxy.Bind(r, &params); err != nil {
+//         http.Error(w, err.Error(), http.StatusBadRequest)
+//         return
+//     }
+//
+// Supported field types are strings, bools, integers, floats, types that
+// implement encoding.TextUnmarshaler (such as UUID and time.Time, which
+// uses RFC 3339 unless a layout is given in the tag) and pointers to them.
+// Fields for variables that were not set are left unchanged.
+//
+// Conversion errors for all fields are returned together in a *BindError.
+// Other errors, such as an unsupported field type, indicate a programming
+// error.
+func Bind(r *http.Request, dst interface{}) error {
+	v := reflect.ValueOf(dst)
+	if v.Kind() != reflect.Ptr || v.IsNil() || v.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("muxy: Bind expects a non-nil pointer to a struct; got %T", dst)
+	}
+	v = v.Elem()
+	t := v.Type()
+	var errs []*VarError
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag, ok := f.Tag.Lookup("muxy")
+		if !ok || tag == "-" {
+			continue
+		}
+		name, layout := tag, ""
+		if j := strings.IndexByte(tag, ','); j >= 0 {
+			name, layout = tag[:j], strings.TrimPrefix(tag[j+1:], "layout=")
+		}
+		s, ok := LookupVar(r, name)
+		if !ok {
+			continue
+		}
+		if f.PkgPath != "" {
+			return fmt.Errorf("muxy: cannot bind variable %q to unexported field %s", name, f.Name)
+		}
+		fv := v.Field(i)
+		if fv.Kind() == reflect.Ptr {
+			if fv.IsNil() {
+				fv.Set(reflect.New(fv.Type().Elem()))
+			}
+			fv = fv.Elem()
+		}
+		err := setField(fv, s, layout)
+		if _, ok := err.(*typeError); ok {
+			return fmt.Errorf("muxy: cannot bind variable %q to field %s: %v", name, f.Name, err)
+		}
+		if err != nil {
+			errs = append(errs, &VarError{Name: name, Err: err})
+		}
+	}
+	if errs != nil {
+		return &BindError{Errors: errs}
+	}
+	return nil
+}
+
+// typeError is returned by setField for unsupported types.
+type typeError struct {
+	typ reflect.Type
+}
+
+func (e *typeError) Error() string {
+	return "unsupported type " + e.typ.String()
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// setField converts s and stores it in v.
+func setField(v reflect.Value, s, layout string) error {
+	if layout != "" && v.Type() == timeType {
+		t, err := time.Parse(layout, s)
+		if err != nil {
+			return err
+		}
+		v.Set(reflect.ValueOf(t))
+		return nil
+	}
+	if u, ok := v.Addr().Interface().(encoding.TextUnmarshaler); ok {
+		return u.UnmarshalText([]byte(s))
+	}
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(s)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i, err := strconv.ParseInt(s, 10, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetInt(i)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		i, err := strconv.ParseUint(s, 10, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetUint(i)
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(s, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetFloat(f)
+	default:
+		return &typeError{v.Type()}
+	}
+	return nil
+}
@@ -0,0 +1,367 @@
+package muxy
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// Host sets a host template that requests must match. The template can
+// contain variables in the form "{name}", which match a single host label,
+// or "{name:regex}". For example:
+//
+//     r.Route("/").Host("{subdomain}.example.com")
+//
+// The port is ignored unless the template contains one. Host variables are
+// stored in the request context along with the path variables, and must be
+// passed to Router.URL to build the URL, which is then absolute.
+//
+// It panics if the template is invalid; use TryHost for templates that are
+// not known at compile time.
+func (r *Route) Host(tpl string) *Route {
+	if err := r.TryHost(tpl); err != nil {
+		panic(err)
+	}
+	return r
+}
+
+// TryHost sets a host template that requests must match, or returns a
+// *RouteError if the template is invalid; see Host.
+func (r *Route) TryHost(tpl string) error {
+	t, err := newTemplate(tpl, "[^.]+", true)
+	if err != nil {
+		return &RouteError{Pattern: r.Pattern, Err: err}
+	}
+	r.host = t
+	return nil
+}
+
+// Schemes sets the URL schemes that requests must match, such as "https".
+// When building a URL for a route with a host template, the first scheme
+// is used.
+func (r *Route) Schemes(schemes ...string) *Route {
+	for _, s := range schemes {
+		r.schemes = append(r.schemes, strings.ToLower(s))
+	}
+	return r
+}
+
+// Queries adds query values that requests must match, passed as key/value
+// pairs. Values are templates which can contain variables in the form
+// "{name}" or "{name:regex}". An empty value only requires the key to be
+// present. For example:
+//
+//     r.Route("/search").Queries("q", "{query}", "format", "json")
+//
+// It panics if the pairs are invalid; use TryQueries for values that are
+// not known at compile time.
+func (r *Route) Queries(pairs ...string) *Route {
+	if err := r.TryQueries(pairs...); err != nil {
+		panic(err)
+	}
+	return r
+}
+
+// TryQueries adds query values that requests must match, or returns a
+// *RouteError if there's an odd number of arguments or a template is
+// invalid, in which case no query is added; see Queries.
+func (r *Route) TryQueries(pairs ...string) error {
+	if len(pairs)%2 != 0 {
+		return &RouteError{Pattern: r.Pattern, Err: fmt.Errorf("muxy: odd number of query pairs: %v", pairs)}
+	}
+	var queries []query
+	for i := 0; i < len(pairs); i += 2 {
+		t, err := newTemplate(pairs[i+1], ".*", false)
+		if err != nil {
+			return &RouteError{Pattern: r.Pattern, Err: err}
+		}
+		queries = append(queries, query{pairs[i], t})
+	}
+	r.queries = append(r.queries, queries...)
+	return nil
+}
+
+// HasConditions reports whether the route has host, scheme or query
+// conditions.
+func (r *Route) HasConditions() bool {
+	return r.host != nil || r.schemes != nil || r.queries != nil
+}
+
+// Matches reports whether the request satisfies the host, scheme and query
+// conditions of the route. Matchers call it after matching the request
+// path. The returned host and query variables are key/value pairs.
+func (r *Route) Matches(req *http.Request) (vars []string, ok bool) {
+	if r.schemes != nil && !contains(r.schemes, scheme(req)) {
+		return nil, false
+	}
+	if r.host != nil {
+		host := req.Host
+		if !r.host.hasPort() {
+			host = stripPort(host)
+		}
+		if vars, ok = r.host.match(host, vars); !ok {
+			return nil, false
+		}
+	}
+	if r.queries != nil {
+		values := req.URL.Query()
+		for _, q := range r.queries {
+			v, found := values[q.key]
+			if !found {
+				return nil, false
+			}
+			if q.value.Raw == "" {
+				continue
+			}
+			if vars, ok = q.value.match(v[0], vars); !ok {
+				return nil, false
+			}
+		}
+	}
+	return vars, true
+}
+
+// build returns a URL string for the route, using the matcher to build the
+// path. Variables used by the host and query templates are extracted from
+// vars; the remaining ones are passed to the matcher.
+func (r *Route) build(vars ...string) (string, error) {
+	if len(vars)%2 != 0 {
+		return "", fmt.Errorf("muxy: odd number of variable pairs: %v", vars)
+	}
+	cvars := map[string]string{}
+	var pvars []string
+	for i := 0; i < len(vars); i += 2 {
+		if r.conditionVar(vars[i]) {
+			cvars[vars[i]] = vars[i+1]
+		} else {
+			pvars = append(pvars, vars[i], vars[i+1])
+		}
+	}
+	path, err := r.Router.Router.matcher.Build(r, pvars...)
+	if err != nil {
+		return "", err
+	}
+	b := new(bytes.Buffer)
+	if r.host != nil {
+		host, err := r.host.build(cvars)
+		if err != nil {
+			return "", err
+		}
+		scheme := "http"
+		if r.schemes != nil {
+			scheme = r.schemes[0]
+		}
+		b.WriteString(scheme + "://" + host)
+	}
+	b.WriteString(path)
+	for i, q := range r.queries {
+		v, err := q.value.build(cvars)
+		if err != nil {
+			return "", err
+		}
+		if i == 0 {
+			b.WriteByte('?')
+		} else {
+			b.WriteByte('&')
+		}
+		b.WriteString(url.QueryEscape(q.key) + "=" + url.QueryEscape(v))
+	}
+	return b.String(), nil
+}
+
+// conditionVar reports whether a variable is used by the host or query
+// templates.
+func (r *Route) conditionVar(name string) bool {
+	if r.host != nil && contains(r.host.Names, name) {
+		return true
+	}
+	for _, q := range r.queries {
+		if contains(q.value.Names, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// query is a query condition.
+type query struct {
+	key   string
+	value *Template
+}
+
+// -----------------------------------------------------------------------------
+
+// Template is a parsed template with variables in the form "{name}" or
+// "{name:regexp}", as used for host and query conditions. Matchers with the
+// same syntax can use it through ParseTemplate.
+type Template struct {
+	// Raw holds the template as given.
+	Raw string
+	// Regexp matches the whole template, with a group for each variable.
+	Regexp *regexp.Regexp
+	// Parts holds the literal parts; there is one more than variables.
+	Parts []string
+	// Names holds the variable names.
+	Names []string
+	// Patterns holds the variable regexps, as given or the default.
+	Patterns []string
+	// Regexps holds the variable regexps, compiled to match whole values.
+	Regexps []*regexp.Regexp
+}
+
+// ParseTemplate parses a template, using def as the regexp for variables
+// which don't define one. Variable names must be unique, and regexps can't
+// have capturing groups.
+func ParseTemplate(tpl, def string) (*Template, error) {
+	return newTemplate(tpl, def, false)
+}
+
+// newTemplate parses a template; see ParseTemplate. If fold is true,
+// matching is case-insensitive.
+func newTemplate(tpl, def string, fold bool) (*Template, error) {
+	t := &Template{Raw: tpl}
+	pattern := new(bytes.Buffer)
+	if fold {
+		pattern.WriteString("(?i)")
+	}
+	pattern.WriteString("^")
+	idxs, err := braceIndices(tpl)
+	if err != nil {
+		return nil, err
+	}
+	end := 0
+	for i := 0; i < len(idxs); i += 2 {
+		lit, v := tpl[end:idxs[i]], tpl[idxs[i]:idxs[i+1]]
+		end = idxs[i+1]
+		name, re := v[1:len(v)-1], def
+		if k := strings.IndexByte(name, ':'); k >= 0 {
+			name, re = name[:k], name[k+1:]
+		}
+		if name == "" || re == "" {
+			return nil, fmt.Errorf("muxy: bad variable %q in %q", v, tpl)
+		}
+		if contains(t.Names, name) {
+			return nil, fmt.Errorf("muxy: duplicated variable %q in %q", name, tpl)
+		}
+		vre, err := regexp.Compile("^(?:" + re + ")$")
+		if err != nil {
+			return nil, fmt.Errorf("muxy: bad variable %q in %q: %v", v, tpl, err)
+		}
+		if vre.NumSubexp() != 0 {
+			return nil, fmt.Errorf("muxy: variable %q in %q has capturing groups; only non-capturing groups are accepted", name, tpl)
+		}
+		t.Parts = append(t.Parts, lit)
+		t.Names = append(t.Names, name)
+		t.Patterns = append(t.Patterns, re)
+		t.Regexps = append(t.Regexps, vre)
+		pattern.WriteString(regexp.QuoteMeta(lit) + "(" + re + ")")
+	}
+	s := tpl[end:]
+	t.Parts = append(t.Parts, s)
+	pattern.WriteString(regexp.QuoteMeta(s) + "$")
+	if t.Regexp, err = regexp.Compile(pattern.String()); err != nil {
+		return nil, fmt.Errorf("muxy: bad template %q: %v", tpl, err)
+	}
+	return t, nil
+}
+
+// braceIndices returns the first level curly brace indices from a string.
+// It returns an error in case of unbalanced braces.
+func braceIndices(s string) ([]int, error) {
+	var level, idx int
+	var idxs []int
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '{':
+			if level++; level == 1 {
+				idx = i
+			}
+		case '}':
+			if level--; level == 0 {
+				idxs = append(idxs, idx, i+1)
+			} else if level < 0 {
+				return nil, fmt.Errorf("muxy: unbalanced braces in %q", s)
+			}
+		}
+	}
+	if level != 0 {
+		return nil, fmt.Errorf("muxy: unbalanced braces in %q", s)
+	}
+	return idxs, nil
+}
+
+// hasPort reports whether a host template contains a port, looking only at
+// its literal parts so that colons in variables are ignored.
+func (t *Template) hasPort() bool {
+	for _, p := range t.Parts {
+		if strings.IndexByte(p, ':') >= 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// match matches s against the template, appending the variables to vars as
+// key/value pairs.
+func (t *Template) match(s string, vars []string) ([]string, bool) {
+	m := t.Regexp.FindStringSubmatch(s)
+	if m == nil {
+		return vars, false
+	}
+	for i, k := range t.Names {
+		vars = append(vars, k, m[i+1])
+	}
+	return vars, true
+}
+
+// build returns the template filled with the given variables.
+func (t *Template) build(vars map[string]string) (string, error) {
+	b := new(bytes.Buffer)
+	for i, k := range t.Names {
+		v, ok := vars[k]
+		if !ok {
+			return "", &VarError{Name: k, Err: ErrMissingVar}
+		}
+		if !t.Regexps[i].MatchString(v) {
+			return "", &VarError{Name: k, Err: fmt.Errorf("muxy: value %q does not match %q for variable", v, t.Raw)}
+		}
+		b.WriteString(t.Parts[i])
+		b.WriteString(v)
+	}
+	b.WriteString(t.Parts[len(t.Names)])
+	return b.String(), nil
+}
+
+// -----------------------------------------------------------------------------
+
+// scheme returns the URL scheme of the request.
+func scheme(r *http.Request) string {
+	if r.URL.Scheme != "" {
+		return strings.ToLower(r.URL.Scheme)
+	}
+	if r.TLS != nil {
+		return "https"
+	}
+	return "http"
+}
+
+// stripPort removes the port from a host, if any.
+func stripPort(host string) string {
+	i := strings.LastIndexByte(host, ':')
+	if i < 0 || strings.IndexByte(host[i:], ']') >= 0 {
+		return host
+	}
+	return host[:i]
+}
+
+func contains(s []string, v string) bool {
+	for _, x := range s {
+		if x == v {
+			return true
+		}
+	}
+	return false
+}
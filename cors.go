@@ -0,0 +1,166 @@
+package muxy
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CORSOptions configures Cross-Origin Resource Sharing for a router, group
+// or route.
+type CORSOptions struct {
+	// AllowedOrigins holds the origins allowed to make cross-origin
+	// requests, such as "https://example.com". An origin can contain one
+	// "*" to match any sequence of characters, as in "https://*.example.com";
+	// "*" alone allows any origin.
+	AllowedOrigins []string
+	// AllowedHeaders holds the request headers allowed in cross-origin
+	// requests, besides the CORS-safelisted ones. "*" allows any header.
+	AllowedHeaders []string
+	// ExposedHeaders holds the response headers that clients are allowed
+	// to access.
+	ExposedHeaders []string
+	// AllowCredentials indicates whether requests can include credentials
+	// such as cookies.
+	AllowCredentials bool
+	// MaxAge indicates how long the results of a preflight request can be
+	// cached. Zero omits the header.
+	MaxAge time.Duration
+}
+
+// CORS sets the CORS options for the routes registered in the router.
+//
+// Options are inherited by groups and can be overridden for each route.
+// Preflight requests to routes with CORS options are answered using the
+// route's registered methods, and CORS headers are added to the responses
+// of actual requests. Both happen before the route middleware runs, so
+// preflight requests don't reach it, and responses written by middleware,
+// such as authentication errors, can be read by clients.
+func (r *Router) CORS(opts *CORSOptions) *Router {
+	r.cors = opts
+	return r
+}
+
+// CORS sets the CORS options for the route, overriding the ones set in its
+// router. Pass empty options to disable CORS for the route.
+func (r *Route) CORS(opts *CORSOptions) *Route {
+	r.cors = opts
+	return r
+}
+
+// corsOptions returns the CORS options for the route, or nil if there's
+// none.
+func (r *Route) corsOptions() *CORSOptions {
+	if r.cors != nil {
+		return r.cors
+	}
+	for g := r.Router; g != nil; g = g.parent {
+		if g.cors != nil {
+			return g.cors
+		}
+	}
+	return nil
+}
+
+// serveCORS adds CORS headers for a request with an allowed origin.
+// It returns true if the request was a preflight request and the response
+// was written.
+func (r *Route) serveCORS(w http.ResponseWriter, req *http.Request) bool {
+	opts := r.corsOptions()
+	origin := req.Header.Get("Origin")
+	if opts == nil || origin == "" {
+		return false
+	}
+	h := w.Header()
+	h.Add("Vary", "Origin")
+	if !opts.allowOrigin(origin) {
+		return false
+	}
+	method := req.Header.Get("Access-Control-Request-Method")
+	if req.Method != "OPTIONS" || method == "" {
+		opts.setOrigin(h, origin)
+		if len(opts.ExposedHeaders) > 0 {
+			h.Set("Access-Control-Expose-Headers", strings.Join(opts.ExposedHeaders, ", "))
+		}
+		return false
+	}
+	// Preflight request.
+	h.Add("Vary", "Access-Control-Request-Method")
+	h.Add("Vary", "Access-Control-Request-Headers")
+	if r.methodHandler(method) == nil {
+		return false
+	}
+	headers := parseHeaderList(req.Header.Get("Access-Control-Request-Headers"))
+	for _, name := range headers {
+		if !opts.allowHeader(name) {
+			return false
+		}
+	}
+	opts.setOrigin(h, origin)
+	if _, ok := r.Handlers[""]; ok {
+		h.Set("Access-Control-Allow-Methods", method)
+	} else {
+		h.Set("Access-Control-Allow-Methods", strings.Join(r.allowedMethods(), ", "))
+	}
+	if len(headers) > 0 {
+		h.Set("Access-Control-Allow-Headers", strings.Join(headers, ", "))
+	}
+	if opts.MaxAge > 0 {
+		h.Set("Access-Control-Max-Age", strconv.Itoa(int(opts.MaxAge/time.Second)))
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return true
+}
+
+// setOrigin sets the Access-Control-Allow-Origin header and, if needed,
+// the Access-Control-Allow-Credentials one.
+func (o *CORSOptions) setOrigin(h http.Header, origin string) {
+	if o.AllowCredentials {
+		h.Set("Access-Control-Allow-Credentials", "true")
+	} else if contains(o.AllowedOrigins, "*") {
+		origin = "*"
+	}
+	h.Set("Access-Control-Allow-Origin", origin)
+}
+
+// allowOrigin reports whether the given origin is allowed.
+func (o *CORSOptions) allowOrigin(origin string) bool {
+	for _, v := range o.AllowedOrigins {
+		if i := strings.IndexByte(v, '*'); i < 0 {
+			if strings.EqualFold(v, origin) {
+				return true
+			}
+		} else if len(origin) >= len(v)-1 &&
+			strings.EqualFold(origin[:i], v[:i]) &&
+			strings.EqualFold(origin[len(origin)-len(v)+i+1:], v[i+1:]) {
+			return true
+		}
+	}
+	return false
+}
+
+// allowHeader reports whether the given request header is allowed.
+func (o *CORSOptions) allowHeader(name string) bool {
+	switch http.CanonicalHeaderKey(name) {
+	case "Accept", "Accept-Language", "Content-Language", "Content-Type":
+		return true
+	}
+	for _, v := range o.AllowedHeaders {
+		if v == "*" || strings.EqualFold(v, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseHeaderList splits a comma-separated list of header names.
+func parseHeaderList(s string) []string {
+	var names []string
+	for _, name := range strings.Split(s, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, strings.ToLower(name))
+		}
+	}
+	return names
+}
@@ -0,0 +1,67 @@
+package muxy
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+)
+
+var (
+	// ErrDuplicateName is returned when a route name is already in use.
+	ErrDuplicateName = errors.New("muxy: duplicated route name")
+	// ErrUnknownRoute is returned when building a URL for a route name that
+	// was not registered.
+	ErrUnknownRoute = errors.New("muxy: unknown route")
+	// ErrMissingVar is returned when building a URL without a value for one
+	// of the route variables.
+	ErrMissingVar = errors.New("muxy: missing route variable")
+	// ErrUnknownHost is returned when building a URL for a host that was
+	// not registered in a HostSwitch.
+	ErrUnknownHost = errors.New("muxy: unknown host")
+)
+
+// RouteError records an error and the route that caused it.
+type RouteError struct {
+	// Name holds the route name, if any.
+	Name string
+	// Pattern holds the route pattern, if known.
+	Pattern string
+	// Err holds the underlying error.
+	Err error
+}
+
+func (e *RouteError) Error() string {
+	var info []string
+	if e.Name != "" {
+		info = append(info, "route "+strconv.Quote(e.Name))
+	}
+	if e.Pattern != "" {
+		info = append(info, "pattern "+strconv.Quote(e.Pattern))
+	}
+	if info == nil {
+		return e.Err.Error()
+	}
+	return e.Err.Error() + " (" + strings.Join(info, ", ") + ")"
+}
+
+// Unwrap returns the underlying error.
+func (e *RouteError) Unwrap() error {
+	return e.Err
+}
+
+// VarError records an error and the route variable that caused it.
+type VarError struct {
+	// Name holds the variable name.
+	Name string
+	// Err holds the underlying error.
+	Err error
+}
+
+func (e *VarError) Error() string {
+	return e.Err.Error() + " " + strconv.Quote(e.Name)
+}
+
+// Unwrap returns the underlying error.
+func (e *VarError) Unwrap() error {
+	return e.Err
+}
@@ -0,0 +1,248 @@
+package muxy
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"path"
+	"sort"
+	"strings"
+)
+
+// contextKey is the type of the context keys used by this package.
+type contextKey int
+
+const (
+	allowedKey contextKey = iota
+	varsKey
+	routeKey
+)
+
+// AllowedMethods returns the methods allowed for the matched route, as
+// reported in the Allow header. It is set in the request context passed to
+// MethodNotAllowed handlers and to automatic OPTIONS responses.
+func AllowedMethods(r *http.Request) []string {
+	m, _ := r.Context().Value(allowedKey).([]string)
+	return m
+}
+
+// CurrentRoute returns the route that matched the request, or nil if no
+// route matched. It is set in the request context passed to the route
+// middleware and handlers.
+func CurrentRoute(r *http.Request) *Route {
+	route, _ := r.Context().Value(routeKey).(*Route)
+	return route
+}
+
+// CurrentPattern returns the pattern of the route that matched the request,
+// as in "/users/:id", or an empty string if no route matched.
+func CurrentPattern(r *http.Request) string {
+	if route := CurrentRoute(r); route != nil {
+		return route.Pattern
+	}
+	return ""
+}
+
+// NotFound sets the handler served when no route matches a request.
+//
+// Handlers set in a group are used for requests whose path starts with the
+// static part of the group pattern, which is the part before the first
+// variable or wildcard; the group with the longest matching prefix wins.
+// The default handler replies with http.NotFound.
+func (r *Router) NotFound(h http.Handler) *Router {
+	if r.notFound == nil {
+		r.Router.notFoundRouters = append(r.Router.notFoundRouters, r)
+	}
+	r.notFound = h
+	return r
+}
+
+// MethodNotAllowed sets the handler served when a route matches a request
+// but has no handler for the request method.
+//
+// Handlers are inherited by groups: the handler used for a route is the
+// one set in the router that registered it or in its closest ancestor.
+// The Allow header is set before calling the handler, and the allowed
+// methods are available through AllowedMethods.
+func (r *Router) MethodNotAllowed(h http.Handler) *Router {
+	r.methodNotAllowed = h
+	return r
+}
+
+// notFoundHandler returns the NotFound handler for the given request.
+func (r *Router) notFoundHandler(req *http.Request) http.Handler {
+	var h http.Handler
+	n := -1
+	for _, g := range r.Router.notFoundRouters {
+		if p := staticPrefix(g.Pattern); len(p) > n && hasPathPrefix(req.URL.Path, p) {
+			h, n = g.notFound, len(p)
+		}
+	}
+	if h == nil {
+		return http.HandlerFunc(http.NotFound)
+	}
+	return h
+}
+
+// methodNotAllowedHandler returns the MethodNotAllowed handler set in the
+// router or in its closest ancestor.
+func (r *Router) methodNotAllowedHandler() http.Handler {
+	for ; r != nil; r = r.parent {
+		if r.methodNotAllowed != nil {
+			return r.methodNotAllowed
+		}
+	}
+	return allowHandler(http.StatusMethodNotAllowed)
+}
+
+// -----------------------------------------------------------------------------
+
+// ServeHTTP dispatches the request to the handler registered for the
+// request method. Matchers return the matched route as the handler.
+//
+// HEAD requests are served by the GET handler if there's no HEAD handler,
+// and OPTIONS requests are answered with the allowed methods if there's no
+// OPTIONS handler. Methods without a handler are passed to the
+// MethodNotAllowed handler, unless a handler was registered for any method.
+//
+// All of the above is wrapped by the middleware of the route's router.
+// The route is stored in the request context; see CurrentRoute.
+//
+// If the route has CORS options, preflight requests are answered and CORS
+// headers are added to the response before the middleware runs, so that
+// responses written by middleware carry them too; see Router.CORS.
+func (r *Route) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	req = req.WithContext(context.WithValue(req.Context(), routeKey, r))
+	if r.serveCORS(w, req) {
+		return
+	}
+	if r.handler != nil {
+		r.handler.ServeHTTP(w, req)
+		return
+	}
+	r.dispatch(w, req)
+}
+
+// compile wraps the route dispatcher with the route middleware.
+func (r *Route) compile() {
+	r.handler = chain(r.Middleware(), http.HandlerFunc(r.dispatch))
+}
+
+// Middleware returns the middleware that wraps the route handlers, in
+// order: the middleware of the main router and of each group down to the
+// route's router, followed by the one carried over by Mount. Its length is
+// the middleware count reported by RoutesHandler.
+func (r *Route) Middleware() []func(http.Handler) http.Handler {
+	return append(r.Router.middleware(), r.mounted...)
+}
+
+// dispatch serves the request without the router middleware.
+func (r *Route) dispatch(w http.ResponseWriter, req *http.Request) {
+	if h := r.methodHandler(req.Method); h != nil {
+		h.ServeHTTP(w, req)
+		return
+	}
+	if len(r.Handlers) == 0 {
+		r.Router.Router.notFoundHandler(req).ServeHTTP(w, req)
+		return
+	}
+	allowed := r.allowedMethods()
+	w.Header().Set("Allow", strings.Join(allowed, ", "))
+	req = req.WithContext(context.WithValue(req.Context(), allowedKey, allowed))
+	if req.Method == "OPTIONS" {
+		allowHandler(http.StatusOK).ServeHTTP(w, req)
+		return
+	}
+	r.Router.methodNotAllowedHandler().ServeHTTP(w, req)
+}
+
+// methodHandler returns the handler registered for the given HTTP method,
+// or nil if there's none.
+func (r *Route) methodHandler(method string) http.Handler {
+	if h, ok := r.Handlers[method]; ok {
+		return h
+	}
+	switch method {
+	case "OPTIONS":
+		return nil
+	case "HEAD":
+		if h, ok := r.Handlers["GET"]; ok {
+			return h
+		}
+	}
+	return r.Handlers[""]
+}
+
+// allowedMethods returns the sorted methods allowed for the route.
+func (r *Route) allowedMethods() []string {
+	allowed := []string{"OPTIONS"}
+	for m := range r.Handlers {
+		if m != "" && m != "OPTIONS" {
+			allowed = append(allowed, m)
+		}
+	}
+	if _, ok := r.Handlers["GET"]; ok {
+		if _, ok := r.Handlers["HEAD"]; !ok {
+			allowed = append(allowed, "HEAD")
+		}
+	}
+	sort.Strings(allowed)
+	return allowed
+}
+
+// allowHandler returns a handler that replies with the given status code
+// in plain text. The Allow header is set by the caller.
+func allowHandler(code int) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.WriteHeader(code)
+		fmt.Fprintln(w, code, http.StatusText(code))
+	})
+}
+
+// -----------------------------------------------------------------------------
+
+// BadRequest replies to the request with an HTTP 400 bad request error.
+// Matchers return it as the handler for requests with malformed paths.
+func BadRequest(w http.ResponseWriter, r *http.Request) {
+	http.Error(w, "400 bad request", http.StatusBadRequest)
+}
+
+// CleanPath returns the canonical path for p, eliminating . and .. elements
+// and keeping a trailing slash.
+//
+// Borrowed from net/http.
+func CleanPath(p string) string {
+	if p == "" {
+		return "/"
+	}
+	if p[0] != '/' {
+		p = "/" + p
+	}
+	np := path.Clean(p)
+	// path.Clean removes trailing slash except for root;
+	// put the trailing slash back if necessary.
+	if p[len(p)-1] == '/' && np != "/" {
+		np += "/"
+	}
+	return np
+}
+
+// staticPrefix returns the pattern segments before the first one with a
+// variable or wildcard.
+func staticPrefix(pattern string) string {
+	if i := strings.IndexAny(pattern, ":*{"); i >= 0 {
+		return pattern[:strings.LastIndexByte(pattern[:i], '/')+1]
+	}
+	return pattern
+}
+
+// hasPathPrefix reports whether path starts with prefix, ending at a
+// segment boundary unless the prefix ends with a slash.
+func hasPathPrefix(path, prefix string) bool {
+	if !strings.HasPrefix(path, prefix) {
+		return false
+	}
+	return len(path) == len(prefix) || prefix == "" ||
+		prefix[len(prefix)-1] == '/' || path[len(prefix)] == '/'
+}
@@ -0,0 +1,119 @@
+package muxy
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// HostSwitch dispatches requests to different routers by host. For example:
+//
+//     hs := muxy.NewHostSwitch()
+//     hs.Handle("example.com", site)
+//     hs.Handle("{tenant}.example.com", tenants)
+//     hs.Handle("*.example.org", legacy)
+//     http.ListenAndServe(":8080", hs)
+//
+// Each router serves its own NotFound handler for unmatched paths; the
+// HostSwitch NotFound handler is only served for unknown hosts.
+type HostSwitch struct {
+	hosts    []*hostRouter
+	notFound http.Handler
+}
+
+// NewHostSwitch returns a new, empty HostSwitch.
+func NewHostSwitch() *HostSwitch {
+	return &HostSwitch{}
+}
+
+// hostRouter is a router registered in a HostSwitch.
+type hostRouter struct {
+	// tpl holds the host template, or nil for wildcard subdomains.
+	tpl *Template
+	// suffix holds the domain for wildcard subdomains, starting with a dot.
+	suffix string
+	// router holds the router serving the host.
+	router *Router
+}
+
+// Handle registers the router for requests whose host matches the given
+// template, which is as in Route.Host: "{tenant}.example.com" matches a
+// single label and stores it as a route variable. A leading "*." matches
+// any number of subdomains, as in "*.example.com".
+//
+// Hosts without variables or wildcards are tried first, and the rest are
+// tried in registration order.
+func (s *HostSwitch) Handle(host string, r *Router) *HostSwitch {
+	h := &hostRouter{router: r}
+	if strings.HasPrefix(host, "*.") {
+		h.suffix = strings.ToLower(host[1:])
+	} else {
+		t, err := newTemplate(host, "[^.]+", true)
+		if err != nil {
+			panic(err)
+		}
+		h.tpl = t
+	}
+	if h.tpl != nil && h.tpl.Names == nil {
+		i := 0
+		for i < len(s.hosts) && s.hosts[i].tpl != nil && s.hosts[i].tpl.Names == nil {
+			i++
+		}
+		s.hosts = append(s.hosts[:i], append([]*hostRouter{h}, s.hosts[i:]...)...)
+	} else {
+		s.hosts = append(s.hosts, h)
+	}
+	return s
+}
+
+// NotFound sets the handler served for requests to unknown hosts. The
+// default handler replies with http.NotFound.
+func (s *HostSwitch) NotFound(h http.Handler) *HostSwitch {
+	s.notFound = h
+	return s
+}
+
+// ServeHTTP dispatches the request to the router registered for its host.
+func (s *HostSwitch) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	h, vars := s.match(req.Host)
+	if h == nil {
+		if s.notFound != nil {
+			s.notFound.ServeHTTP(w, req)
+		} else {
+			http.NotFound(w, req)
+		}
+		return
+	}
+	h.router.ServeHTTP(w, WithVars(req, vars...))
+}
+
+// BuildURL returns an absolute URL for the given route name and variables
+// using the router registered for the given host; see Router.BuildHostURL.
+func (s *HostSwitch) BuildURL(host, name string, vars ...string) (string, error) {
+	h, _ := s.match(host)
+	if h == nil {
+		return "", fmt.Errorf("%w %q", ErrUnknownHost, host)
+	}
+	return h.router.BuildHostURL(host, name, vars...)
+}
+
+// match returns the router for the given host and its host variables.
+func (s *HostSwitch) match(host string) (*hostRouter, []string) {
+	for _, h := range s.hosts {
+		if h.tpl == nil {
+			v := strings.ToLower(stripPort(host))
+			if len(v) > len(h.suffix) && strings.HasSuffix(v, h.suffix) {
+				return h, nil
+			}
+			continue
+		}
+		v := host
+		if !h.tpl.hasPort() {
+			v = stripPort(host)
+		}
+		if vars, ok := h.tpl.match(v, nil); ok {
+			return h, vars
+		}
+	}
+	return nil, nil
+}
@@ -0,0 +1,96 @@
+package loader
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// parseJSON parses a JSON document into a node tree.
+func parseJSON(data []byte) (*node, error) {
+	p := &jsonParser{data: data, dec: json.NewDecoder(bytes.NewReader(data))}
+	p.dec.UseNumber()
+	n, err := p.value()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := p.dec.Token(); err != io.EOF {
+		return nil, p.errorf("unexpected data after the top-level value")
+	}
+	return n, nil
+}
+
+// jsonParser builds a node tree from the tokens of a JSON decoder.
+type jsonParser struct {
+	data []byte
+	dec  *json.Decoder
+}
+
+// line returns the line of the last token read.
+func (p *jsonParser) line() int {
+	return bytes.Count(p.data[:p.dec.InputOffset()], []byte("\n")) + 1
+}
+
+func (p *jsonParser) errorf(format string, args ...interface{}) *Error {
+	return &Error{Line: p.line(), Msg: fmt.Sprintf(format, args...)}
+}
+
+// value parses the next value.
+func (p *jsonParser) value() (*node, error) {
+	tok, err := p.dec.Token()
+	if err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, p.errorf("%v", err)
+	}
+	n := &node{line: p.line()}
+	switch v := tok.(type) {
+	case json.Delim:
+		if v == '{' {
+			n.kind = mappingNode
+			for p.dec.More() {
+				tok, err := p.dec.Token()
+				if err != nil {
+					return nil, p.errorf("%v", err)
+				}
+				key := tok.(string)
+				for _, k := range n.keys {
+					if k == key {
+						return nil, p.errorf("duplicated key %q", key)
+					}
+				}
+				line := p.line()
+				value, err := p.value()
+				if err != nil {
+					return nil, err
+				}
+				if value.kind == scalarNode {
+					// Report scalar errors at the key.
+					value.line = line
+				}
+				n.keys = append(n.keys, key)
+				n.values = append(n.values, value)
+			}
+		} else {
+			n.kind = sequenceNode
+			for p.dec.More() {
+				item, err := p.value()
+				if err != nil {
+					return nil, err
+				}
+				n.items = append(n.items, item)
+			}
+		}
+		if _, err := p.dec.Token(); err != nil {
+			return nil, p.errorf("%v", err)
+		}
+	case nil:
+	case string:
+		n.value = v
+	default:
+		n.value = fmt.Sprint(v)
+	}
+	return n, nil
+}
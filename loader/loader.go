@@ -0,0 +1,365 @@
+// Package loader registers muxy routes declared in a YAML or JSON file.
+//
+// A file declares a group, with an optional pattern and name prefix,
+// middleware, routes and nested groups:
+//
+//     pattern: /api
+//     name: "api:"
+//     middleware: [logging]
+//     routes:
+//       - pattern: /users/:id
+//         name: user
+//         methods: [GET, PUT]
+//         handler: users.show
+//     groups:
+//       - pattern: /admin
+//         name: "admin:"
+//         middleware: [auth]
+//         routes:
+//           - pattern: /stats
+//             handler: admin.stats
+//             middleware: [cache]
+//
+// Groups are created with Router.Group and Router.Name, so patterns and
+// names are prefixed as usual, and group middleware is added with
+// Router.Use. Route middleware only wraps the route handler. Routes
+// without methods are served for any method.
+//
+// Handlers and middleware are referenced by the IDs and names they were
+// registered with in a Loader. YAML files are parsed with gopkg.in/yaml.v3.
+package loader
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"strings"
+
+	"github.com/gorilla/muxy"
+)
+
+// Error records an error in a routes file and the line that caused it.
+type Error struct {
+	// File holds the file name, if known.
+	File string
+	// Line holds the line number, starting at 1.
+	Line int
+	// Msg holds the error description.
+	Msg string
+}
+
+func (e *Error) Error() string {
+	if e.File == "" {
+		return fmt.Sprintf("line %d: %s", e.Line, e.Msg)
+	}
+	return fmt.Sprintf("%s:%d: %s", e.File, e.Line, e.Msg)
+}
+
+// ErrorList is a list of errors found in a routes file.
+type ErrorList []*Error
+
+func (l ErrorList) Error() string {
+	s := make([]string, len(l))
+	for i, err := range l {
+		s[i] = err.Error()
+	}
+	return strings.Join(s, "\n")
+}
+
+// -----------------------------------------------------------------------------
+
+// Loader registers routes declared in files, resolving handler IDs and
+// middleware names.
+type Loader struct {
+	handlers   map[string]http.Handler
+	middleware map[string]func(http.Handler) http.Handler
+}
+
+// New returns a new Loader without handlers or middleware.
+func New() *Loader {
+	return &Loader{
+		handlers:   map[string]http.Handler{},
+		middleware: map[string]func(http.Handler) http.Handler{},
+	}
+}
+
+// Handler registers a handler with the given ID.
+func (l *Loader) Handler(id string, h http.Handler) *Loader {
+	l.handlers[id] = h
+	return l
+}
+
+// Middleware registers a middleware with the given name.
+func (l *Loader) Middleware(name string, mw func(http.Handler) http.Handler) *Loader {
+	l.middleware[name] = mw
+	return l
+}
+
+// LoadFile reads a routes file and registers its routes in r; see Load.
+func (l *Loader) LoadFile(r *muxy.Router, filename string) error {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+	return l.Load(r, filename, data)
+}
+
+// Load registers the routes declared in data in r. The format is JSON if
+// the file name ends with ".json", or if it is not ".yaml" or ".yml" and
+// data starts with "{"; otherwise it is YAML.
+//
+// The file is fully validated before registering any route: unknown keys,
+// missing patterns, and unknown handler IDs or middleware names are
+// reported as an ErrorList. Errors registering a route, such as a pattern
+// rejected by the matcher, stop loading and are returned as an *Error;
+// routes declared before it stay registered.
+func (l *Loader) Load(r *muxy.Router, filename string, data []byte) error {
+	var root *node
+	var err error
+	switch ext := strings.ToLower(filepath.Ext(filename)); {
+	case ext == ".json" || ext != ".yaml" && ext != ".yml" && bytes.HasPrefix(bytes.TrimSpace(data), []byte("{")):
+		root, err = parseJSON(data)
+	default:
+		root, err = parseYAML(data)
+	}
+	if err != nil {
+		if e, ok := err.(*Error); ok {
+			e.File = filename
+		}
+		return err
+	}
+	d := &decoder{loader: l}
+	g := d.group(root)
+	if d.errs != nil {
+		for _, e := range d.errs {
+			e.File = filename
+		}
+		return d.errs
+	}
+	if err := l.register(r, g); err != nil {
+		err.File = filename
+		return err
+	}
+	return nil
+}
+
+// register registers a group and its routes.
+func (l *Loader) register(r *muxy.Router, g *group) *Error {
+	r = r.Group(g.pattern)
+	if g.name != "" {
+		r.Name(g.name)
+	}
+	for _, name := range g.middleware {
+		r.Use(l.middleware[name])
+	}
+	for _, rt := range g.routes {
+		route, err := r.TryRoute(rt.pattern)
+		if err != nil {
+			return &Error{Line: rt.line, Msg: err.Error()}
+		}
+		if rt.name != "" {
+			if err := route.TryName(rt.name); err != nil {
+				return &Error{Line: rt.line, Msg: err.Error()}
+			}
+		}
+		h := l.handlers[rt.handler]
+		for i := len(rt.middleware) - 1; i >= 0; i-- {
+			h = l.middleware[rt.middleware[i]](h)
+		}
+		route.Handle(h, rt.methods...)
+	}
+	for _, sub := range g.groups {
+		if err := l.register(r, sub); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// -----------------------------------------------------------------------------
+
+// group is a decoded group declaration.
+type group struct {
+	pattern    string
+	name       string
+	middleware []string
+	routes     []*route
+	groups     []*group
+}
+
+// route is a decoded route declaration.
+type route struct {
+	line       int
+	pattern    string
+	name       string
+	methods    []string
+	handler    string
+	middleware []string
+}
+
+// decoder decodes a parsed file, collecting validation errors.
+type decoder struct {
+	loader *Loader
+	errs   ErrorList
+}
+
+func (d *decoder) errorf(n *node, format string, args ...interface{}) {
+	d.errs = append(d.errs, &Error{Line: n.line, Msg: fmt.Sprintf(format, args...)})
+}
+
+// group decodes a group mapping.
+func (d *decoder) group(n *node) *group {
+	g := &group{}
+	if !d.expect(n, mappingNode) {
+		return g
+	}
+	for i, k := range n.keys {
+		v := n.values[i]
+		switch k {
+		case "pattern":
+			g.pattern = d.scalar(v)
+		case "name":
+			g.name = d.scalar(v)
+		case "middleware":
+			g.middleware = d.middleware(v)
+		case "routes":
+			if d.expect(v, sequenceNode) {
+				for _, item := range v.items {
+					g.routes = append(g.routes, d.route(item))
+				}
+			}
+		case "groups":
+			if d.expect(v, sequenceNode) {
+				for _, item := range v.items {
+					g.groups = append(g.groups, d.group(item))
+				}
+			}
+		default:
+			d.errorf(v, "unknown group key %q", k)
+		}
+	}
+	return g
+}
+
+// route decodes a route mapping.
+func (d *decoder) route(n *node) *route {
+	rt := &route{line: n.line}
+	if !d.expect(n, mappingNode) {
+		return rt
+	}
+	var handler *node
+	for i, k := range n.keys {
+		v := n.values[i]
+		switch k {
+		case "pattern":
+			rt.pattern = d.scalar(v)
+		case "name":
+			rt.name = d.scalar(v)
+		case "handler":
+			rt.handler, handler = d.scalar(v), v
+		case "methods":
+			for _, m := range d.strings(v) {
+				if m == "" || strings.IndexFunc(m, isNotTokenChar) >= 0 {
+					d.errorf(v, "invalid method %q", m)
+				}
+				rt.methods = append(rt.methods, strings.ToUpper(m))
+			}
+		case "middleware":
+			rt.middleware = d.middleware(v)
+		default:
+			d.errorf(v, "unknown route key %q", k)
+		}
+	}
+	if rt.pattern == "" {
+		d.errorf(n, "missing route pattern")
+	}
+	if handler == nil {
+		d.errorf(n, "missing route handler")
+	} else if _, ok := d.loader.handlers[rt.handler]; !ok {
+		d.errorf(handler, "unknown handler %q", rt.handler)
+	}
+	return rt
+}
+
+// middleware decodes a list of middleware names.
+func (d *decoder) middleware(n *node) []string {
+	names := d.strings(n)
+	for i, name := range names {
+		if _, ok := d.loader.middleware[name]; !ok {
+			line := n
+			if n.kind == sequenceNode {
+				line = n.items[i]
+			}
+			d.errorf(line, "unknown middleware %q", name)
+		}
+	}
+	return names
+}
+
+// strings decodes a sequence of scalars.
+func (d *decoder) strings(n *node) []string {
+	if !d.expect(n, sequenceNode) {
+		return nil
+	}
+	s := make([]string, len(n.items))
+	for i, item := range n.items {
+		s[i] = d.scalar(item)
+	}
+	return s
+}
+
+// scalar decodes a scalar.
+func (d *decoder) scalar(n *node) string {
+	if !d.expect(n, scalarNode) {
+		return ""
+	}
+	return n.value
+}
+
+// expect reports whether the node has the given kind, recording an error
+// otherwise.
+func (d *decoder) expect(n *node, kind nodeKind) bool {
+	if n.kind != kind {
+		d.errorf(n, "expected %s; got %s", kind, n.kind)
+		return false
+	}
+	return true
+}
+
+// isNotTokenChar reports whether r can't be part of an HTTP method.
+func isNotTokenChar(r rune) bool {
+	return r <= ' ' || r >= 0x7f || strings.ContainsRune("\"(),/:;<=>?@[\\]{}", r)
+}
+
+// -----------------------------------------------------------------------------
+
+type nodeKind int
+
+const (
+	scalarNode nodeKind = iota
+	mappingNode
+	sequenceNode
+)
+
+func (k nodeKind) String() string {
+	switch k {
+	case mappingNode:
+		return "mapping"
+	case sequenceNode:
+		return "sequence"
+	}
+	return "scalar"
+}
+
+// node is a parsed YAML or JSON value and the line where it starts.
+// Null values are empty scalars.
+type node struct {
+	kind   nodeKind
+	line   int
+	value  string  // for scalars
+	keys   []string // for mappings
+	values []*node  // for mappings
+	items  []*node  // for sequences
+}
@@ -0,0 +1,210 @@
+package loader
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/muxy/matchers/mpath"
+)
+
+func text(s string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, s)
+	})
+}
+
+func header(name string) func(http.Handler) http.Handler {
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Add("X-Middleware", name)
+			h.ServeHTTP(w, r)
+		})
+	}
+}
+
+func newLoader() *Loader {
+	return New().
+		Handler("users.show", text("user")).
+		Handler("admin.stats", text("stats")).
+		Middleware("logging", header("logging")).
+		Middleware("auth", header("auth")).
+		Middleware("cache", header("cache"))
+}
+
+const routesYAML = `# Routes.
+---
+pattern: /api
+name: "api:"
+middleware: [logging]
+routes:
+- pattern: /users/:id
+  name: user
+  methods: [get, PUT]
+  handler: users.show
+groups:
+  - pattern: /admin
+    name: 'admin:'
+    middleware:
+      - auth
+    routes:
+      - pattern: /stats # a comment
+        name: stats
+        handler: admin.stats
+        middleware: [cache]
+`
+
+const routesJSON = `{
+  "pattern": "/api",
+  "name": "api:",
+  "middleware": ["logging"],
+  "routes": [
+    {"pattern": "/users/:id", "name": "user", "methods": ["get", "PUT"], "handler": "users.show"}
+  ],
+  "groups": [
+    {
+      "pattern": "/admin",
+      "name": "admin:",
+      "middleware": ["auth"],
+      "routes": [
+        {"pattern": "/stats", "name": "stats", "handler": "admin.stats", "middleware": ["cache"]}
+      ]
+    }
+  ]
+}`
+
+func TestLoad(t *testing.T) {
+	for _, v := range []struct{ filename, data string }{
+		{"routes.yaml", routesYAML},
+		{"routes.json", routesJSON},
+		{"routes", routesJSON},
+	} {
+		r := mpath.New()
+		if err := newLoader().Load(r, v.filename, []byte(v.data)); err != nil {
+			t.Errorf("%s: unexpected error: %v", v.filename, err)
+			continue
+		}
+		for _, test := range []struct {
+			method, path string
+			code         int
+			body, mw     string
+		}{
+			{"GET", "/api/users/1", 200, "user", "logging"},
+			{"PUT", "/api/users/1", 200, "user", "logging"},
+			{"POST", "/api/users/1", 405, "", ""},
+			{"DELETE", "/api/admin/stats", 200, "stats", "logging,auth,cache"},
+			{"GET", "/api/stats", 404, "", ""},
+		} {
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, httptest.NewRequest(test.method, test.path, nil))
+			if w.Code != test.code {
+				t.Errorf("%s: %s %s: expected code %d; got %d", v.filename, test.method, test.path, test.code, w.Code)
+				continue
+			}
+			if test.code != 200 {
+				continue
+			}
+			if w.Body.String() != test.body {
+				t.Errorf("%s: %s %s: expected body %q; got %q", v.filename, test.method, test.path, test.body, w.Body.String())
+			}
+			if mw := strings.Join(w.Header()["X-Middleware"], ","); mw != test.mw {
+				t.Errorf("%s: %s %s: expected middleware %q; got %q", v.filename, test.method, test.path, test.mw, mw)
+			}
+		}
+		for _, test := range []struct {
+			name     string
+			vars     []string
+			expected string
+		}{
+			{"api:user", []string{"id", "42"}, "/api/users/42"},
+			{"api:admin:stats", nil, "/api/admin/stats"},
+		} {
+			u, err := r.BuildURL(test.name, test.vars...)
+			if err != nil || u != test.expected {
+				t.Errorf("%s: %s: expected %q; got %q, %v", v.filename, test.name, test.expected, u, err)
+			}
+		}
+	}
+}
+
+var loadErrorTests = []struct {
+	filename string
+	data     string
+	err      string
+}{
+	{"r.yaml", "routes:\n  - pattern: /a\n    handler: nope\n", "r.yaml:3: unknown handler \"nope\""},
+	{"r.yaml", "routes:\n  - handler: users.show\n", "r.yaml:2: missing route pattern"},
+	{"r.yaml", "routes:\n  - pattern: /a\n", "r.yaml:2: missing route handler"},
+	{"r.yaml", "pattern: /a\npath: /b\n", "r.yaml:2: unknown group key \"path\""},
+	{"r.yaml", "middleware: [auth, nope]\n", "r.yaml:1: unknown middleware \"nope\""},
+	{"r.yaml", "routes:\n  - pattern: /a\n    handler: users.show\n    methods: [GE T]\n", "r.yaml:4: invalid method \"GE T\""},
+	{"r.yaml", "routes: /a\n", "r.yaml:1: expected sequence; got scalar"},
+	{"r.yaml", "routes:\n  - pattern: /a\n    handler: users.show\n    verb: GET\n  - pattern: /b\n", "r.yaml:4: unknown route key \"verb\"\nr.yaml:5: missing route handler"},
+	{"r.yaml", "pattern: /a\npattern: /b\n", "r.yaml:2: duplicated key \"pattern\""},
+	{"r.yaml", "pattern: /a\n  name: b\n", "r.yaml:2: mapping values are not allowed"},
+	{"r.yaml", "pattern\n", "r.yaml:1: expected mapping; got scalar"},
+	{"r.yaml", "pattern: \"/a\n", "r.yaml:2: "},
+	{"r.yaml", "middleware: [auth\n", "r.yaml:1: did not find expected ',' or ']'"},
+	{"r.yaml", "routes:\n  - pattern: /a\n    handler: users.show\n  - pattern: /a/:\n    handler: users.show\n", "r.yaml:4: "},
+	{"r.json", "{\n  \"routes\": [\n    {\"pattern\": \"/a\", \"handler\": \"nope\"}\n  ]\n}", "r.json:3: unknown handler \"nope\""},
+	{"r.json", "{\n  \"pattern\": \"/a\",\n  \"pattern\": \"/b\"\n}", "r.json:3: duplicated key \"pattern\""},
+	{"r.json", "{\n  \"routes\": [\n    {\"pattern\": \"/a\" \"handler\": \"nope\"}\n  ]\n}", "r.json:3: "},
+}
+
+func TestLoadErrors(t *testing.T) {
+	for _, v := range loadErrorTests {
+		err := newLoader().Load(mpath.New(), v.filename, []byte(v.data))
+		if err == nil {
+			t.Errorf("%q: expected error", v.data)
+			continue
+		}
+		if !strings.HasPrefix(err.Error(), v.err) {
+			t.Errorf("%q: expected error %q; got %q", v.data, v.err, err)
+		}
+	}
+}
+
+var yamlTests = []struct {
+	data     string
+	expected string
+}{
+	{"a: b\n", "{a: b}"},
+	{"a: []\nb: ~\nc:\nd: 'null'\n", "{a: [], b: , c: , d: null}"},
+	{"- - a\n  - b\n- c: d\n  e: f\n", "[[a, b], {c: d, e: f}]"},
+	{"a: &x [b, c]\nd: *x\n", "{a: [b, c], d: [b, c]}"},
+	{"", "{}"},
+}
+
+func TestParseYAML(t *testing.T) {
+	for _, v := range yamlTests {
+		n, err := parseYAML([]byte(v.data))
+		if err != nil {
+			t.Errorf("%q: unexpected error: %v", v.data, err)
+			continue
+		}
+		if s := dump(n); s != v.expected {
+			t.Errorf("%q: expected %s; got %s", v.data, v.expected, s)
+		}
+	}
+}
+
+// dump returns a compact representation of a node tree.
+func dump(n *node) string {
+	switch n.kind {
+	case mappingNode:
+		s := make([]string, len(n.keys))
+		for i, k := range n.keys {
+			s[i] = k + ": " + dump(n.values[i])
+		}
+		return "{" + strings.Join(s, ", ") + "}"
+	case sequenceNode:
+		s := make([]string, len(n.items))
+		for i, item := range n.items {
+			s[i] = dump(item)
+		}
+		return "[" + strings.Join(s, ", ") + "]"
+	}
+	return n.value
+}
@@ -0,0 +1,75 @@
+package loader
+
+import (
+	"fmt"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// parseYAML parses a YAML document into a node tree.
+func parseYAML(data []byte) (*node, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, yamlError(err)
+	}
+	if len(doc.Content) == 0 {
+		return &node{kind: mappingNode, line: 1}, nil
+	}
+	return convertYAML(doc.Content[0])
+}
+
+// convertYAML converts a yaml.v3 node to a node tree.
+func convertYAML(y *yaml.Node) (*node, error) {
+	n := &node{line: y.Line}
+	switch y.Kind {
+	case yaml.AliasNode:
+		return convertYAML(y.Alias)
+	case yaml.MappingNode:
+		n.kind = mappingNode
+		for i := 0; i+1 < len(y.Content); i += 2 {
+			k := y.Content[i]
+			if k.Kind != yaml.ScalarNode {
+				return nil, &Error{Line: k.Line, Msg: "mapping keys must be scalars"}
+			}
+			for _, key := range n.keys {
+				if key == k.Value {
+					return nil, &Error{Line: k.Line, Msg: fmt.Sprintf("duplicated key %q", k.Value)}
+				}
+			}
+			v, err := convertYAML(y.Content[i+1])
+			if err != nil {
+				return nil, err
+			}
+			n.keys = append(n.keys, k.Value)
+			n.values = append(n.values, v)
+		}
+	case yaml.SequenceNode:
+		n.kind = sequenceNode
+		for _, item := range y.Content {
+			v, err := convertYAML(item)
+			if err != nil {
+				return nil, err
+			}
+			n.items = append(n.items, v)
+		}
+	default:
+		n.kind = scalarNode
+		if y.Tag != "!!null" {
+			n.value = y.Value
+		}
+	}
+	return n, nil
+}
+
+// yamlError converts a yaml.v3 syntax error, which has the form
+// "yaml: line N: message", to an *Error.
+func yamlError(err error) error {
+	var line int
+	rest := strings.TrimPrefix(err.Error(), "yaml: line ")
+	i := strings.IndexByte(rest, ':')
+	if _, e := fmt.Sscanf(rest, "%d:", &line); e != nil || i < 0 {
+		return err
+	}
+	return &Error{Line: line, Msg: strings.TrimSpace(rest[i+1:])}
+}
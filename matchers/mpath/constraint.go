@@ -0,0 +1,105 @@
+package mpath
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"sync"
+
+	"github.com/gorilla/muxy"
+)
+
+// Constraint reports whether a value is acceptable for a typed variable.
+//
+// Constraints are used both to match requests, so that a value rejected by
+// a constraint falls through to other routes, and to validate the values
+// passed to Build.
+type Constraint func(value string) bool
+
+// ConstraintFactory returns a Constraint for the argument given in a
+// pattern. For ":slug<regex [a-z-]+>", the argument is "[a-z-]+"; it is
+// empty when the type is used without one, as in ":id<int>".
+type ConstraintFactory func(arg string) (Constraint, error)
+
+var (
+	constraintsMu sync.RWMutex
+	constraints   = map[string]ConstraintFactory{
+		"int":   intConstraint,
+		"regex": regexConstraint,
+		"uuid":  uuidConstraint,
+	}
+)
+
+// RegisterConstraint registers a constraint type to be used in patterns as
+// ":name<typ>" or ":name<typ arg>". It panics if typ is already registered.
+//
+// The built-in types are "int", "uuid" and "regex".
+func RegisterConstraint(typ string, f ConstraintFactory) {
+	constraintsMu.Lock()
+	defer constraintsMu.Unlock()
+	if _, ok := constraints[typ]; ok {
+		panic("mpath: constraint type registered twice: " + typ)
+	}
+	constraints[typ] = f
+}
+
+// newConstraint returns the constraint for a spec in the form "typ" or
+// "typ arg".
+func newConstraint(spec string) (Constraint, error) {
+	typ, arg := spec, ""
+	for i := 0; i < len(spec); i++ {
+		if spec[i] == ' ' {
+			typ, arg = spec[:i], spec[i+1:]
+			break
+		}
+	}
+	constraintsMu.RLock()
+	f, ok := constraints[typ]
+	constraintsMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown constraint type %q", typ)
+	}
+	c, err := f(arg)
+	if err != nil {
+		return nil, fmt.Errorf("bad constraint %q: %v", spec, err)
+	}
+	return c, nil
+}
+
+// intConstraint accepts base 10 integers that fit in an int64.
+func intConstraint(arg string) (Constraint, error) {
+	if arg != "" {
+		return nil, fmt.Errorf("unexpected argument")
+	}
+	return func(v string) bool {
+		_, err := strconv.ParseInt(v, 10, 64)
+		return err == nil
+	}, nil
+}
+
+// regexConstraint accepts values entirely matched by the regular expression
+// given as argument.
+func regexConstraint(arg string) (Constraint, error) {
+	if arg == "" {
+		return nil, fmt.Errorf("missing regular expression")
+	}
+	re, err := regexp.Compile("^(?:" + arg + ")$")
+	if err != nil {
+		return nil, err
+	}
+	return re.MatchString, nil
+}
+
+// uuidConstraint accepts UUIDs in their canonical textual form, such as
+// "f81d4fae-7dec-11d0-a765-00a0c91e6bf6", in any letter case.
+func uuidConstraint(arg string) (Constraint, error) {
+	if arg != "" {
+		return nil, fmt.Errorf("unexpected argument")
+	}
+	return isUUID, nil
+}
+
+func isUUID(v string) bool {
+	_, err := muxy.ParseUUID(v)
+	return err == nil
+}
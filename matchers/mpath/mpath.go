@@ -2,28 +2,78 @@ package mpath
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"net/http"
-	"path"
+	"net/url"
 	"strings"
 	"unicode"
 
 	"github.com/gorilla/muxy"
-	//"github.com/gorilla/muxy/encoder"
-	"golang.org/x/net/context"
+	"github.com/gorilla/muxy/encoder"
 )
 
-func NotFoundHandler(h muxy.Handler) func(*matcher) {
+// RedirectTrailingSlash makes the matcher redirect requests that don't match
+// any route, but would match one with a trailing slash added or removed, to
+// the path that matches. The code should be http.StatusMovedPermanently or
+// http.StatusPermanentRedirect; the latter preserves the request method.
+func RedirectTrailingSlash(code int) func(*matcher) {
 	return func(m *matcher) {
-		m.notFoundHandler = h
+		m.slash, m.slashCode = redirectSlash, code
 	}
 }
 
+// MatchTrailingSlash makes the matcher serve requests that don't match any
+// route, but would match one with a trailing slash added or removed, using
+// that route.
+func MatchTrailingSlash() func(*matcher) {
+	return func(m *matcher) {
+		m.slash = matchSlash
+	}
+}
+
+// RedirectCanonicalPath makes the matcher redirect requests for paths that
+// are not canonical, such as "/a/../b" or "/a//b", to the canonical path
+// if it matches a route, as net/http.ServeMux does. The query string is
+// preserved. Without this option, the canonical path is served directly.
+func RedirectCanonicalPath(code int) func(*matcher) {
+	return func(m *matcher) {
+		m.cleanCode = code
+	}
+}
+
+// CaseInsensitive makes the matcher compare static segments using Unicode
+// case folding, so that "/Users/New" matches the pattern "/users/new".
+// Variable values and the literals around them are not folded, and values
+// are stored as sent. With RedirectCanonicalPath, requests are redirected
+// to the path with static segments in their registered casing.
+func CaseInsensitive() func(*matcher) {
+	return func(m *matcher) {
+		m.fold = true
+	}
+}
+
+// slashPolicy defines how requests that only match a route with a trailing
+// slash added or removed are handled. By default, they don't match.
+type slashPolicy int
+
+const (
+	strictSlash slashPolicy = iota
+	redirectSlash
+	matchSlash
+)
+
+// New returns a new muxy.Router that matches URL paths segment by segment.
+//
+// For each segment, static patterns have priority over variables, and
+// variables over wildcards. Variables with longer literals around them
+// come first, and typed variables come before untyped ones. If a segment
+// matches a higher priority pattern but the rest of the path doesn't,
+// lower priority patterns are tried.
 func New(options ...func(*matcher)) *muxy.Router {
 	m := &matcher{
-		root:            &node{edges: map[string]*node{}},
-		patterns:        map[*muxy.Route]*pattern{},
-		notFoundHandler: muxy.HandlerFunc(notFound),
+		root:     &node{edges: map[string]*node{}},
+		patterns: map[*muxy.Route]*pattern{},
 	}
 	for _, o := range options {
 		o(m)
@@ -32,9 +82,25 @@ func New(options ...func(*matcher)) *muxy.Router {
 }
 
 type matcher struct {
-	root            *node
-	patterns        map[*muxy.Route]*pattern
-	notFoundHandler muxy.Handler
+	root      *node
+	patterns  map[*muxy.Route]*pattern
+	slash     slashPolicy
+	slashCode int
+	cleanCode int
+	fold      bool
+}
+
+// config holds the matcher options.
+type config struct {
+	slash     slashPolicy
+	slashCode int
+	cleanCode int
+	fold      bool
+}
+
+// Config returns the matcher options; see muxy.ConfigMatcher.
+func (m *matcher) Config() interface{} {
+	return config{m.slash, m.slashCode, m.cleanCode, m.fold}
 }
 
 func (m *matcher) Route(pattern string) (*muxy.Route, error) {
@@ -42,29 +108,66 @@ func (m *matcher) Route(pattern string) (*muxy.Route, error) {
 	if err != nil {
 		return nil, err
 	}
-	e := m.root.edge(segs)
-	if r := e.leaf; r != nil {
-		return nil, fmt.Errorf("muxy: a route for the pattern %q or equivalent already exists: %q", pattern, r.Pattern)
+	// A pattern with optional segments is registered once for each
+	// number of optional segments present.
+	var edges []*node
+	for _, v := range variants(segs) {
+		e := m.root.edge(m.keys(v))
+		for _, r := range e.leaves {
+			// Routes with host, scheme or query conditions can share a
+			// pattern, but nothing can follow a route without them.
+			if !r.HasConditions() {
+				return nil, fmt.Errorf("muxy: a route for the pattern %q or equivalent already exists: %q", pattern, r.Pattern)
+			}
+		}
+		edges = append(edges, e)
+	}
+	r := &muxy.Route{}
+	for _, e := range edges {
+		e.leaves = append(e.leaves, r)
 	}
-	e.leaf = &muxy.Route{}
-	m.patterns[e.leaf] = newPattern(segs)
-	return e.leaf, nil
+	m.patterns[r] = newPattern(segs)
+	return r, nil
 }
 
-func (m *matcher) Match(c context.Context, r *http.Request) (context.Context, muxy.Handler) {
-	var h muxy.Handler
-	// TODO: use a backward-compatible alternative to URL.RawPath here.
-	path := cleanPath(r.URL.Path)
-	e := m.root.match(path)
-	if e != nil && e.leaf != nil {
-		h = methodHandler(e.leaf.Handlers, r.Method)
+func (m *matcher) Match(r *http.Request) (http.Handler, *http.Request) {
+	escaped := escapedPath(r.URL)
+	path := muxy.CleanPath(escaped)
+	parts, err := splitPath(path)
+	if err != nil {
+		return http.HandlerFunc(muxy.BadRequest), r
+	}
+	code := 0
+	if escaped != "" && escaped != path {
+		code = m.cleanCode
+	}
+	route, cvars := m.root.match(parts, m.foldParts(parts), r)
+	if route == nil && m.slash != strictSlash && path != "/" {
+		if path[len(path)-1] == '/' {
+			path, parts = path[:len(path)-1], parts[:len(parts)-1]
+		} else {
+			path, parts = path+"/", append(parts, "")
+		}
+		route, cvars = m.root.match(parts, m.foldParts(parts), r)
+		if m.slash == redirectSlash {
+			code = m.slashCode
+		}
 	}
-	if h == nil {
-		h = m.notFoundHandler
-	} else {
-		c = m.patterns[e.leaf].setVars(c, path)
+	if route == nil {
+		return nil, r
 	}
-	return c, h
+	if m.fold && m.cleanCode != 0 {
+		if p := m.patterns[route].registeredCase(path, parts); p != path {
+			path = p
+			if code == 0 {
+				code = m.cleanCode
+			}
+		}
+	}
+	if code != 0 {
+		return redirect(path, r.URL.RawQuery, code), r
+	}
+	return route, m.patterns[route].setVars(r, parts, cvars)
 }
 
 func (m *matcher) Build(r *muxy.Route, vars ...string) (string, error) {
@@ -74,295 +177,658 @@ func (m *matcher) Build(r *muxy.Route, vars ...string) (string, error) {
 	return "", fmt.Errorf("muxy: route not found: %v", r)
 }
 
-// -----------------------------------------------------------------------------
-
-// methodHandler returns the handler registered for the given HTTP method.
-func methodHandler(handlers map[string]muxy.Handler, method string) muxy.Handler {
-	if handlers == nil || len(handlers) == 0 {
+// Paths returns the path templates matched by the route; see
+// muxy.PathMatcher. Static segments are decoded.
+func (m *matcher) Paths(r *muxy.Route) [][]muxy.PathPart {
+	p, ok := m.patterns[r]
+	if !ok {
 		return nil
 	}
-	if h, ok := handlers[method]; ok {
-		return h
-	}
-	switch method {
-	case "OPTIONS":
-		return allowHandler(handlers, 200)
-	case "HEAD":
-		if h, ok := handlers["GET"]; ok {
-			return h
+	var paths [][]muxy.PathPart
+	for _, segs := range variants(p.segs) {
+		var parts []muxy.PathPart
+		lit := ""
+		for _, seg := range segs {
+			lit += "/"
+			if seg.typ == staticSegment {
+				lit += seg.name
+				continue
+			}
+			v := muxy.PathPart{Name: seg.name, Wildcard: seg.typ == wildcardSegment}
+			typ, arg := seg.spec, ""
+			if i := strings.IndexByte(seg.spec, ' '); i >= 0 {
+				typ, arg = seg.spec[:i], seg.spec[i+1:]
+			}
+			switch typ {
+			case "int", "uuid":
+				v.Type = typ
+			case "regex":
+				v.Pattern = arg
+			}
+			parts = append(parts, muxy.PathPart{Literal: lit + seg.prefix}, v)
+			lit = seg.suffix
 		}
-		fallthrough
-	default:
-		if h, ok := handlers[""]; ok {
-			return h
+		if lit != "" {
+			parts = append(parts, muxy.PathPart{Literal: lit})
 		}
+		paths = append(paths, parts)
 	}
-	return allowHandler(handlers, 405)
+	return paths
 }
 
-// allowHandler returns a handler that sets a header with the given
-// status code and allowed methods.
-func allowHandler(handlers map[string]muxy.Handler, code int) muxy.Handler {
-	allowed := make([]string, len(handlers)+1)
-	allowed[0] = "OPTIONS"
-	i := 1
-	for m, _ := range handlers {
-		if m != "" && m != "OPTIONS" {
-			allowed[i] = m
-			i++
+// keys returns the segments used as keys in the routing tree: for case
+// insensitive matchers, static segments are folded.
+func (m *matcher) keys(segs []segment) []segment {
+	if !m.fold {
+		return segs
+	}
+	keys := make([]segment, len(segs))
+	for i, seg := range segs {
+		if seg.typ == staticSegment {
+			seg.name = foldCase(seg.name)
 		}
+		keys[i] = seg
 	}
-	return muxy.HandlerFunc(func(c context.Context, w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
-		w.Header().Set("Allow", strings.Join(allowed[:i], ", "))
-		w.WriteHeader(code)
-		fmt.Fprintln(w, code, http.StatusText(code))
-	})
+	return keys
+}
+
+// foldParts returns the path parts used to look up static edges in the
+// routing tree.
+func (m *matcher) foldParts(parts []string) []string {
+	if !m.fold {
+		return parts
+	}
+	keys := make([]string, len(parts))
+	for i, part := range parts {
+		keys[i] = foldCase(part)
+	}
+	return keys
+}
+
+// foldCase maps each rune in s to the smallest rune in its Unicode case
+// folding orbit, so that strings equal under simple case folding have the
+// same result.
+func foldCase(s string) string {
+	return strings.Map(func(r rune) rune {
+		min := r
+		for f := unicode.SimpleFold(r); f != r; f = unicode.SimpleFold(f) {
+			if f < min {
+				min = f
+			}
+		}
+		return min
+	}, s)
 }
 
 // -----------------------------------------------------------------------------
 
 type node struct {
-	edges map[string]*node // static edges, if any
-	vEdge *node            // variable edge, if any
-	wEdge *node            // wildcard edge, if any
-	leaf  *muxy.Route      // leaf value, if any
+	edges  map[string]*node // static edges, if any
+	vEdges []*varEdge       // variable edges, if any
+	wEdge  *node            // wildcard edge, if any
+	leaves []*muxy.Route    // leaf values, if any, in registration order
+}
+
+// varEdge is a variable edge, optionally surrounded by literals and
+// restricted by a constraint.
+type varEdge struct {
+	prefix string     // literal before the variable, if any
+	suffix string     // literal after the variable, if any
+	spec   string     // constraint spec, or empty if unconstrained
+	check  Constraint // constraint, or nil if unconstrained
+	node   *node
+}
+
+// before reports whether e must be tried before o when matching: edges with
+// longer literals go first, then constrained edges, then edges registered
+// earlier.
+func (e *varEdge) before(o *varEdge) bool {
+	if l1, l2 := len(e.prefix)+len(e.suffix), len(o.prefix)+len(o.suffix); l1 != l2 {
+		return l1 > l2
+	}
+	return e.check != nil && o.check == nil
+}
+
+// value returns the variable value for the given path segment, or false
+// if the edge doesn't match it.
+func (e *varEdge) value(part string) (string, bool) {
+	if len(part) <= len(e.prefix)+len(e.suffix) ||
+		!strings.HasPrefix(part, e.prefix) || !strings.HasSuffix(part, e.suffix) {
+		return "", false
+	}
+	v := part[len(e.prefix) : len(part)-len(e.suffix)]
+	if e.check != nil && !e.check(v) {
+		return "", false
+	}
+	return v, true
+}
+
+// varEdge returns the variable edge for the given segment, creating it if
+// needed. Edges are kept in the order used to match them.
+func (n *node) varEdge(seg segment) *node {
+	for _, e := range n.vEdges {
+		if e.prefix == seg.prefix && e.suffix == seg.suffix && e.spec == seg.spec {
+			return e.node
+		}
+	}
+	e := &varEdge{seg.prefix, seg.suffix, seg.spec, seg.check, &node{edges: map[string]*node{}}}
+	i := len(n.vEdges)
+	for i > 0 && e.before(n.vEdges[i-1]) {
+		i--
+	}
+	n.vEdges = append(n.vEdges, nil)
+	copy(n.vEdges[i+1:], n.vEdges[i:])
+	n.vEdges[i] = e
+	return e.node
 }
 
 // edge returns the edge for the given path segments, creating it if needed.
-func (n *node) edge(segs []string) *node {
+func (n *node) edge(segs []segment) *node {
 	for _, seg := range segs {
-		switch seg[0] {
-		case ':':
-			if n.vEdge == nil {
-				n.vEdge = &node{edges: map[string]*node{}}
-			}
-			n = n.vEdge
-		case '*':
+		switch seg.typ {
+		case variableSegment:
+			n = n.varEdge(seg)
+		case wildcardSegment:
 			if n.wEdge == nil {
 				n.wEdge = &node{}
 			}
 			return n.wEdge
 		default:
-			if n.edges[seg] == nil {
-				n.edges[seg] = &node{edges: map[string]*node{}}
+			if n.edges[seg.name] == nil {
+				n.edges[seg.name] = &node{edges: map[string]*node{}}
 			}
-			n = n.edges[seg]
+			n = n.edges[seg.name]
 		}
 	}
 	return n
 }
 
-func (n *node) match(path string) *node {
-	part, path := "", path[1:]
-	for len(path) > 0 {
-		if i := strings.IndexByte(path, '/'); i < 0 {
-			part, path = path, ""
-		} else {
-			part, path = path[:i], path[i+1:]
+// match returns the first route matching the given decoded path segments
+// and request, along with the route's host and query variables.
+//
+// Edges are tried in priority order: the static edge first, then variable
+// edges in their matching order, then the wildcard edge. When a branch
+// dead-ends, because no route under it matches the remaining segments or
+// the request conditions, matching backtracks and tries the next edge.
+//
+// A trailing slash results in an empty last segment, which is never matched
+// by variables. A wildcard matches it as zero segments.
+//
+// Static edges are looked up using keys, which holds the parts as stored in
+// the tree; see matcher.foldParts.
+func (n *node) match(parts, keys []string, r *http.Request) (*muxy.Route, []string) {
+	if len(parts) == 0 {
+		return n.leaf(r)
+	}
+	part := parts[0]
+	if e, ok := n.edges[keys[0]]; ok {
+		if route, cvars := e.match(parts[1:], keys[1:], r); route != nil {
+			return route, cvars
 		}
-		if e, ok := n.edges[part]; ok {
-			n = e
-			continue
+	}
+	if part != "" {
+		for _, e := range n.vEdges {
+			if _, ok := e.value(part); ok {
+				if route, cvars := e.node.match(parts[1:], keys[1:], r); route != nil {
+					return route, cvars
+				}
+			}
 		}
-		if e := n.vEdge; e != nil {
-			n = e
-			continue
+	}
+	if n.wEdge != nil {
+		return n.wEdge.leaf(r)
+	}
+	return nil, nil
+}
+
+// leaf returns the first route in the node matching the request
+// conditions, along with the route's host and query variables.
+func (n *node) leaf(r *http.Request) (*muxy.Route, []string) {
+	for _, route := range n.leaves {
+		if cvars, ok := route.Matches(r); ok {
+			return route, cvars
 		}
-		return n.wEdge
 	}
-	return n
+	return nil, nil
 }
 
 // -----------------------------------------------------------------------------
 
 // newPattern returns a pattern for the given path segments.
-func newPattern(segs []string) *pattern {
-	p := pattern{}
-	b := new(bytes.Buffer)
+func newPattern(segs []segment) *pattern {
+	p := pattern{segs: segs}
 	for _, s := range segs {
-		switch s[0] {
-		case ':':
-			s = s[1:]
-		case '*':
-		default:
-			b.WriteByte('/')
-			b.WriteString(s)
-			s = ""
+		if s.typ != staticSegment {
+			p.keys = append(p.keys, muxy.Variable(s.name))
 		}
-		if s != "" {
-			p.parts = append(p.parts, b.String()+"/")
-			b.Reset()
-			p.parts = append(p.parts, s)
-			p.keys = append(p.keys, muxy.Variable(s))
-		}
-	}
-	if b.Len() != 0 {
-		p.parts = append(p.parts, b.String())
 	}
 	return &p
 }
 
 type pattern struct {
-	parts []string
-	keys  []muxy.Variable
+	segs []segment
+	keys []muxy.Variable
 }
 
-// setVars sets the route variables in the context.
+// setVars sets the route variables in the request context.
 //
-// Since the path matched already, we can make some assumptions: the path
-// starts with a slash and there are no empty or dotted path segments.
+// Since the path matched already, we can make some assumptions: there is
+// one decoded path part for each pattern segment, except for a wildcard,
+// which takes the rest of the path, and for absent optional segments.
 //
 // For the values:
 //
 //     p := pattern{
-//         parts: []string{"/foo/bar/", "v1", "/baz/", "v2", "*"},
-//         keys:  []muxy.Variable{"v1", "v2", "*"},
+//         segs: []segment{{typ: staticSegment, name: "foo"},
+//             {typ: variableSegment, name: "v1"},
+//             {typ: staticSegment, name: "baz"},
+//             {typ: variableSegment, name: "v2"},
+//             {typ: wildcardSegment, name: "*"}},
+//         keys: []muxy.Variable{"v1", "v2", "*"},
 //     }
-//     path := "/foo/bar/var1/baz/var2/x/y/z"
+//     parts := []string{"foo", "var1", "baz", "var2", "x", "y", "z"}
 //
 // The variables will be:
 //
-//     vars = []string{"var1", "var2", "x/y/z"}
-func (p *pattern) setVars(c context.Context, path string) context.Context {
-	if len(p.keys) == 0 {
-		return c
-	}
-	path, idx := path[1:], 0
-	vars := make([]string, len(p.keys))
-	for _, part := range p.parts {
-		switch part[0] {
-		case '/':
-			path = path[len(part)-1:]
-		case '*':
-			vars[idx] = path
+//     vars = []string{"v1", "var1", "v2", "var2", "*", "x/y/z"}
+//
+// Host and query variables, passed in cvars as key/value pairs, are set as
+// well.
+func (p *pattern) setVars(r *http.Request, parts []string, cvars []string) *http.Request {
+	if len(p.keys) == 0 && len(cvars) == 0 {
+		return r
+	}
+	vars := make([]string, 0, len(p.keys)*2+len(cvars))
+	for i, seg := range p.segs {
+		if i == len(parts) || seg.optional && parts[i] == "" {
 			break
-		default:
-			if i := strings.IndexByte(path, '/'); i < 0 {
-				vars[idx] = path
-				break
-			} else {
-				vars[idx] = path[:i]
-				path = path[i+1:]
-				idx++
+		}
+		switch seg.typ {
+		case variableSegment:
+			vars = append(vars, seg.name, parts[i][len(seg.prefix):len(parts[i])-len(seg.suffix)])
+		case wildcardSegment:
+			vars = append(vars, seg.name, strings.Join(parts[i:], "/"))
+			wildcard := parts[i:]
+			if len(wildcard) == 1 && wildcard[0] == "" {
+				wildcard = []string{}
 			}
+			r = r.WithContext(context.WithValue(r.Context(), wildcardKey, wildcard))
 		}
 	}
-	return &varsCtx{c, p.keys, vars}
+	return muxy.WithVars(r, append(vars, cvars...)...)
 }
 
-func (p *pattern) build(vars ...string) (string, error) {
-	if len(p.keys)*2 != len(vars) {
-		return "", fmt.Errorf("muxy: expected %d arguments, got %d: %v", len(p.keys)*2, len(vars), vars)
+// registeredCase returns the given clean, escaped path with its static
+// segments replaced by the ones in the pattern, if they differ. It is used
+// by case insensitive matchers to redirect to the registered casing.
+func (p *pattern) registeredCase(path string, parts []string) string {
+	escaped := strings.Split(path[1:], "/")
+	changed := false
+	for i, seg := range p.segs {
+		if i == len(parts) || seg.typ == wildcardSegment {
+			break
+		}
+		if seg.typ == staticSegment && parts[i] != seg.name {
+			escaped[i] = encodeSegment(seg.name)
+			changed = true
+		}
 	}
+	if !changed {
+		return path
+	}
+	return "/" + strings.Join(escaped, "/")
+}
+
+// build returns a URL path for the pattern using the given variables, which
+// are passed as key/value pairs.
+//
+// A missing variable results in a *muxy.VarError wrapping
+// muxy.ErrMissingVar, unless the variable is optional, in which case its
+// segment is omitted.
+func (p *pattern) build(vars ...string) (string, error) {
 	b := new(bytes.Buffer)
-Loop:
-	for _, part := range p.parts {
-		if part[0] == '/' {
-			b.WriteString(part)
+	n, absent := 0, ""
+	for _, seg := range p.segs {
+		if seg.typ == staticSegment {
+			b.WriteByte('/')
+			b.WriteString(encodeSegment(seg.name))
 			continue
 		}
-		for i, s := 0, len(vars); i < s; i += 2 {
-			if vars[i] == part {
-				b.WriteString(vars[i+1])
-				vars[i] = ""
-				continue Loop
+		v, ok := lookup(vars, seg.name)
+		if !ok {
+			if !seg.optional {
+				return "", &muxy.VarError{Name: seg.name, Err: muxy.ErrMissingVar}
 			}
+			if absent == "" {
+				absent = seg.name
+			}
+			continue
+		}
+		if absent != "" {
+			return "", &muxy.VarError{Name: absent, Err: muxy.ErrMissingVar}
 		}
-		return "", fmt.Errorf("muxy: missing argument for variable %q", part)
+		n++
+		b.WriteByte('/')
+		if seg.typ == wildcardSegment {
+			if err := encodeWildcard(b, v); err != nil {
+				return "", &muxy.VarError{Name: seg.name, Err: err}
+			}
+			continue
+		}
+		if v == "" {
+			return "", &muxy.VarError{Name: seg.name, Err: fmt.Errorf("muxy: empty value for variable")}
+		}
+		if seg.check != nil && !seg.check(v) {
+			return "", &muxy.VarError{Name: seg.name, Err: fmt.Errorf("muxy: value %q does not satisfy <%s> for variable", v, seg.spec)}
+		}
+		b.WriteString(encodeSegment(seg.prefix + v + seg.suffix))
+	}
+	if n*2 != len(vars) {
+		return "", fmt.Errorf("muxy: expected %d arguments, got %d: %v", n*2, len(vars), vars)
+	}
+	if b.Len() == 0 {
+		return "/", nil
 	}
 	return b.String(), nil
 }
 
-// -----------------------------------------------------------------------------
+// lookup returns the value for the given key in a list of key/value pairs.
+func lookup(vars []string, key string) (string, bool) {
+	for i := 0; i+1 < len(vars); i += 2 {
+		if vars[i] == key {
+			return vars[i+1], true
+		}
+	}
+	return "", false
+}
 
-func parse(pattern string) ([]string, error) {
-	pattern = cleanPath(pattern)
-	count := 0
-	for _, r := range pattern {
-		if r == '/' {
-			count++
+// encodeSegment percent encodes s as a single path segment. Dot segments
+// are escaped as well, so that they are not removed by path cleaning.
+func encodeSegment(s string) string {
+	switch s {
+	case "":
+		return ""
+	case ".":
+		return "%2E"
+	case "..":
+		return "%2E%2E"
+	}
+	return encoder.EncodePathSegment(s)
+}
+
+// encodeWildcard writes a wildcard value to b, encoding each of its slash
+// separated segments. Only the last segment can be empty, as the wildcard
+// would not match the resulting path otherwise; an empty value is zero
+// segments.
+func encodeWildcard(b *bytes.Buffer, s string) error {
+	segs := strings.Split(s, "/")
+	for i, seg := range segs {
+		if seg == "" && i != len(segs)-1 {
+			return fmt.Errorf("muxy: empty segment in %q for variable", s)
 		}
+		if i > 0 {
+			b.WriteByte('/')
+		}
+		b.WriteString(encodeSegment(seg))
+	}
+	return nil
+}
+
+// -----------------------------------------------------------------------------
+
+type segmentType int
+
+const (
+	staticSegment segmentType = iota
+	variableSegment
+	wildcardSegment
+)
+
+// segment is a parsed pattern segment. For static segments, name holds the
+// decoded segment value; otherwise it holds the variable name, which is "*"
+// for unnamed wildcards. Variables
+// can also hold decoded literals around them and, if typed, the constraint
+// spec and its Constraint.
+type segment struct {
+	typ      segmentType
+	name     string
+	prefix   string
+	suffix   string
+	spec     string
+	check    Constraint
+	optional bool
+}
+
+// String returns the segment as written in a pattern, except for static
+// segments, which are returned decoded.
+func (s segment) String() string {
+	switch {
+	case s.typ == staticSegment || s.name == "*":
+		return s.name
+	case s.typ == wildcardSegment:
+		return "*" + s.name
+	}
+	v := s.prefix + ":" + s.name
+	if s.spec != "" {
+		v += "<" + s.spec + ">"
+	}
+	v += s.suffix
+	if s.optional {
+		v += "?"
 	}
-	segs := make([]string, count)
-	idx := 0
+	return v
+}
+
+// parse splits a pattern into segments.
+//
+// Static segments are percent-decoded, so "/foo%2Fbar" is a single segment
+// that matches the request path "/foo%2Fbar" but not "/foo/bar".
+//
+// Variable segments ending with "?", as in "/archive/:year/:month?", are
+// optional. Optional segments can only be followed by other optional
+// segments.
+//
+// A wildcard, which must be the last segment, can be named as in
+// "/static/*path"; otherwise its variable name is "*".
+func parse(pattern string) ([]segment, error) {
+	pattern = muxy.CleanPath(pattern)
+	segs := make([]segment, 0, strings.Count(pattern, "/"))
 	part, path := "", pattern[1:]
-	for len(path) > 0 {
+	for {
 		i := strings.IndexByte(path, '/')
 		if i < 0 {
 			part, path = path, ""
 		} else {
 			part, path = path[:i], path[i+1:]
 		}
-		switch part[0] {
-		case ':':
-			if len(part) == 1 {
-				return nil, fmt.Errorf("empty variable name")
-			}
-			for k, r := range part[1:] {
-				if k == 0 {
-					if r != '_' && !unicode.IsLetter(r) {
-						return nil, fmt.Errorf("expected underscore or letter starting a variable name; got %q", r)
-					}
-				} else if r != '_' && !unicode.IsLetter(r) && !unicode.IsDigit(r) {
-					return nil, fmt.Errorf("unexpected %q in variable name", r)
+		switch {
+		case strings.HasPrefix(part, "*"):
+			name := part
+			if len(part) > 1 {
+				name = part[1:]
+				if end, err := scanName(name); err != nil {
+					return nil, err
+				} else if end != len(name) {
+					return nil, fmt.Errorf("unexpected wildcard: %q", part)
 				}
 			}
-		case '*':
-			if len(part) != 1 {
-				return nil, fmt.Errorf("unexpected wildcard: %q", part)
-			}
 			if i >= 0 {
-				return nil, fmt.Errorf("wildcard must be at the end of a pattern; got: .../*/%v", path)
+				return nil, fmt.Errorf("wildcard must be at the end of a pattern; got: .../%v/%v", part, path)
+			}
+			segs = append(segs, segment{typ: wildcardSegment, name: name})
+		case strings.IndexByte(part, ':') >= 0:
+			seg, err := parseVariable(part)
+			if err != nil {
+				return nil, err
 			}
+			segs = append(segs, seg)
+		default:
+			s, err := encoder.DecodePathSegment(part)
+			if err != nil {
+				return nil, err
+			}
+			segs = append(segs, segment{typ: staticSegment, name: s})
+		}
+		if n := len(segs); n > 1 && segs[n-2].optional && !segs[n-1].optional {
+			return nil, fmt.Errorf("optional segments must be at the end of a pattern; got: %q", pattern)
+		}
+		if i < 0 {
+			return segs, nil
 		}
-		segs[idx] = part
-		idx++
 	}
-	return segs, nil
 }
 
-// cleanPath returns the canonical path for p, eliminating . and .. elements.
-//
-// Borrowed from net/http.
-func cleanPath(p string) string {
-	if p == "" {
-		return "/"
+// variants returns the segments to register for a pattern: the segments
+// without optional ones, and then adding each optional segment in turn.
+func variants(segs []segment) [][]segment {
+	i := len(segs)
+	for i > 0 && segs[i-1].optional {
+		i--
 	}
-	if p[0] != '/' {
-		p = "/" + p
+	var v [][]segment
+	for ; i <= len(segs); i++ {
+		if i == 0 {
+			// The root path.
+			v = append(v, []segment{{typ: staticSegment}})
+		} else {
+			v = append(v, segs[:i])
+		}
 	}
-	np := path.Clean(p)
-	// path.Clean removes trailing slash except for root;
-	// put the trailing slash back if necessary.
-	if p[len(p)-1] == '/' && np != "/" {
-		np += "/"
+	return v
+}
+
+// parseVariable parses a variable segment in the form ":name" or
+// ":name<spec>", optionally surrounded by literals as in "v:version" or
+// ":name<spec>.json". A segment can only have one variable; a literal colon
+// must be escaped as "%3A".
+func parseVariable(part string) (segment, error) {
+	seg := segment{typ: variableSegment}
+	if strings.HasSuffix(part, "?") {
+		seg.optional, part = true, part[:len(part)-1]
+	}
+	i := strings.IndexByte(part, ':')
+	prefix, rest := part[:i], part[i+1:]
+	end, err := scanName(rest)
+	if err != nil {
+		return seg, err
 	}
-	return np
+	seg.name, rest = rest[:end], rest[end:]
+	if strings.HasPrefix(rest, "<") {
+		j := closingAngle(rest)
+		if j < 0 {
+			return seg, fmt.Errorf("expected '>' closing the constraint of %q", part)
+		}
+		seg.spec, rest = rest[1:j], rest[j+1:]
+		if seg.spec == "" {
+			return seg, fmt.Errorf("empty constraint in %q", part)
+		}
+		check, err := newConstraint(seg.spec)
+		if err != nil {
+			return seg, err
+		}
+		seg.check = check
+	}
+	if strings.IndexByte(rest, ':') >= 0 {
+		return seg, fmt.Errorf("more than one variable in segment %q", part)
+	}
+	if seg.prefix, err = encoder.DecodePathSegment(prefix); err != nil {
+		return seg, err
+	}
+	if seg.suffix, err = encoder.DecodePathSegment(rest); err != nil {
+		return seg, err
+	}
+	return seg, nil
 }
 
-// -----------------------------------------------------------------------------
+// scanName returns the length of the variable name that starts s, which
+// ends at the first rune that can't be part of it.
+func scanName(s string) (int, error) {
+	for k, r := range s {
+		if k == 0 {
+			if r != '_' && !unicode.IsLetter(r) {
+				return 0, fmt.Errorf("expected underscore or letter starting a variable name; got %q", r)
+			}
+		} else if r != '_' && !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			return k, nil
+		}
+	}
+	if s == "" {
+		return 0, fmt.Errorf("empty variable name")
+	}
+	return len(s), nil
+}
 
-// varsCtx carries a key-variables mapping. It implements Context.Value() and
-// delegates all other calls to the embedded Context.
-type varsCtx struct {
-	context.Context
-	keys []muxy.Variable
-	vars []string
+// closingAngle returns the index of the '>' closing the '<' that starts s,
+// or -1 if there's none.
+func closingAngle(s string) int {
+	depth := 0
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '<':
+			depth++
+		case '>':
+			if depth--; depth == 0 {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// escapedPath returns the URL path as sent by the client, falling back to
+// the escaped form of u.Path when the raw path is not set.
+func escapedPath(u *url.URL) string {
+	if u.RawPath != "" {
+		return u.RawPath
+	}
+	return u.EscapedPath()
 }
 
-func (c *varsCtx) Value(key interface{}) interface{} {
-	for k, v := range c.keys {
-		if v == key {
-			return c.vars[k]
+// splitPath splits a clean, escaped path into decoded segments.
+func splitPath(path string) ([]string, error) {
+	parts := strings.Split(path[1:], "/")
+	for i, part := range parts {
+		s, err := encoder.DecodePathSegment(part)
+		if err != nil {
+			return nil, err
 		}
+		parts[i] = s
 	}
-	return c.Context.Value(key)
+	return parts, nil
 }
 
 // -----------------------------------------------------------------------------
 
-// notFound replies to the request with an HTTP 404 not found error.
-func notFound(c context.Context, w http.ResponseWriter, r *http.Request) {
-	http.Error(w, "404 page not found", http.StatusNotFound)
+// Wildcard returns the decoded segments matched by the wildcard of the
+// route, or nil if the route has no wildcard. Unlike the wildcard variable,
+// which joins the segments with slashes, segments can contain encoded
+// slashes:
+//
+//     // For the pattern "/files/*path" and the path "/files/a%2Fb/c":
+//     muxy.Var(r, "path") // "a/b/c"
+//     mpath.Wildcard(r)   // []string{"a/b", "c"}
+//
+// A wildcard matching zero segments, as in "/files/", results in an empty
+// slice.
+func Wildcard(r *http.Request) []string {
+	v, _ := r.Context().Value(wildcardKey).([]string)
+	return v
+}
+
+// contextKey is the type of the context keys used by this package.
+type contextKey int
+
+const (
+	wildcardKey contextKey = iota
+)
+
+// -----------------------------------------------------------------------------
+
+// redirect returns a handler that redirects to the given escaped path and
+// query.
+func redirect(path, query string, code int) http.Handler {
+	if query != "" {
+		path += "?" + query
+	}
+	return http.RedirectHandler(path, code)
 }
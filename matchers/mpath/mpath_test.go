@@ -1,7 +1,12 @@
 package mpath
 
 import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
+
+	"github.com/gorilla/muxy"
 )
 
 type parseTest struct {
@@ -25,51 +30,569 @@ var parseTests = []parseTest{
 	{"/*", []string{"*"}},
 	{"/foo/*", []string{"foo", "*"}},
 	{"/foo/:bar/*", []string{"foo", ":bar", "*"}},
+	{"/static/*path", []string{"static", "*path"}},
 	// percent encodings
-	//{"/foo%2Fbar", {"foo/bar"}},
-	//{"/%E4%B8%96%E7%95%8C", {"世界"}},
-	//{"/%25", {"%"}},
-	//{"/%25/", {"%", ""}},
+	{"/foo%2Fbar", []string{"foo/bar"}},
+	{"/%E4%B8%96%E7%95%8C", []string{"世界"}},
+	{"/%25", []string{"%"}},
+	{"/%25/", []string{"%", ""}},
+	// typed variables
+	{"/:id<int>", []string{":id<int>"}},
+	{"/:slug<regex [a-z-]+>/", []string{":slug<regex [a-z-]+>", ""}},
+	{"/foo/:uuid<uuid>", []string{"foo", ":uuid<uuid>"}},
+	// variables with literals
+	{"/:name.json", []string{":name.json"}},
+	{"/v:version/", []string{"v:version", ""}},
+	{"/img-:id<int>.png", []string{"img-:id<int>.png"}},
+	{"/%3A:name%3A", []string{"::name:"}},
+	{"/:id<regex a<b>>x", []string{":id<regex a<b>>x"}},
+	// optional variables
+	{"/:page?", []string{":page?"}},
+	{"/archive/:year/:month?", []string{"archive", ":year", ":month?"}},
+	{"/:year?/:month<int>?", []string{":year?", ":month<int>?"}},
 	// parsing errors
-	{"/*/", nil},     // invalid wildcard
-	{"/*name", nil},  // invalid wildcard
-	{"/:1name", nil}, // invalid variable name
-	//{"/%2x", nil},    // invalid percent encoding
-	//{"/%2", nil},     // invalid percent encoding
+	{"/*/", nil},              // invalid wildcard
+	{"/*1name", nil},          // invalid wildcard name
+	{"/*na-me", nil},          // invalid wildcard name
+	{"/*name/", nil},          // wildcard not at the end
+	{"/:1name", nil},          // invalid variable name
+	{"/%2x", nil},             // invalid percent encoding
+	{"/%2", nil},              // invalid percent encoding
+	{"/:id<int", nil},         // unterminated constraint
+	{"/:id<>", nil},           // empty constraint
+	{"/:<int>", nil},          // empty variable name
+	{"/:id<unknown>", nil},    // unknown constraint type
+	{"/:id<int 10>", nil},     // unexpected argument
+	{"/:id<regex>", nil},      // missing argument
+	{"/:id<regex [a-z>", nil}, // invalid regular expression
+	{"/:a-:b", nil},           // more than one variable
+	{"/a:", nil},              // empty variable name
+	{"/:name%2", nil},         // invalid percent encoding
+	{"/:a?/b", nil},           // optional segment before static
+	{"/:a?/:b", nil},          // optional segment before variable
+	{"/:a?/", nil},            // optional segment before trailing slash
 }
 
 func TestParse(t *testing.T) {
 	for _, v := range parseTests {
-		parts, err := parse(v.pattern)
-		if (err == nil) != (parts != nil) {
+		segs, err := parse(v.pattern)
+		if (err == nil) != (v.parts != nil) {
 			if err == nil {
 				t.Errorf("Expected error parsing %q", v.pattern)
 			} else {
 				t.Errorf("%q: expected %v; failed to parse with error %q", v.pattern, v.parts, err)
 			}
 		} else if err == nil {
-			if !equalParts(v.parts, parts) {
-				t.Errorf("%q: expected %v; got %v", v.pattern, v.parts, parts)
+			if !equalParts(v.parts, segs) {
+				t.Errorf("%q: expected %v; got %v", v.pattern, v.parts, segs)
 			}
 		}
 	}
 }
 
 func TestRoute(t *testing.T) {
+	m := &matcher{
+		root:     &node{edges: map[string]*node{}},
+		patterns: map[*muxy.Route]*pattern{},
+	}
+	if _, err := m.Route("/foo/:bar"); err != nil {
+		t.Fatal(err)
+	}
+	for _, pattern := range []string{"/foo/:bar", "/foo/:baz", "/foo/./:bar"} {
+		if _, err := m.Route(pattern); err == nil {
+			t.Errorf("%q: expected duplicated route error", pattern)
+		}
+	}
+}
+
+type matchTest struct {
+	pattern string
+	path    string
+	match   bool
+	vars    map[string]string
+}
+
+var matchTests = []matchTest{
+	// static
+	{"/", "/", true, nil},
+	{"/foo", "/foo", true, nil},
+	{"/foo", "/foo/", false, nil},
+	{"/foo/", "/foo/", true, nil},
+	{"/foo/bar", "/foo/bar", true, nil},
+	{"/foo/bar", "/foo/baz", false, nil},
+	// variable
+	{"/:foo", "/x", true, map[string]string{"foo": "x"}},
+	{"/:foo", "/", false, nil},
+	{"/:foo/", "/x/", true, map[string]string{"foo": "x"}},
+	{"/:foo/:bar", "/x/y", true, map[string]string{"foo": "x", "bar": "y"}},
+	{"/foo/:bar", "/foo/", false, nil},
+	// wildcard
+	{"/*", "/x/y/z", true, map[string]string{"*": "x/y/z"}},
+	{"/foo/*", "/foo/x/", true, map[string]string{"*": "x/"}},
+	{"/foo/:bar/*", "/foo/x/y/z", true, map[string]string{"bar": "x", "*": "y/z"}},
+	{"/foo/*", "/foo", false, nil},
+	{"/foo/*", "/foo/", true, map[string]string{"*": ""}},
+	{"/*", "/", true, map[string]string{"*": ""}},
+	{"/static/*path", "/static/css/a.css", true, map[string]string{"path": "css/a.css"}},
+	// cleaned paths
+	{"/foo/bar", "/foo/../foo//bar", true, nil},
+	// percent encodings
+	{"/foo%2Fbar", "/foo%2Fbar", true, nil},
+	{"/foo%2Fbar", "/foo/bar", false, nil},
+	{"/世界", "/%E4%B8%96%E7%95%8C", true, nil},
+	{"/files/:name", "/files/a%2Fb", true, map[string]string{"name": "a/b"}},
+	{"/files/:name", "/files/a%20b", true, map[string]string{"name": "a b"}},
+	{"/files/*", "/files/a%20b/c", true, map[string]string{"*": "a b/c"}},
+	// variables with literals
+	{"/files/:name.json", "/files/a.b.json", true, map[string]string{"name": "a.b"}},
+	{"/files/:name.json", "/files/.json", false, nil},
+	{"/files/:name.json", "/files/a.xml", false, nil},
+	{"/v:version/", "/v2/", true, map[string]string{"version": "2"}},
+	{"/v:version/", "/x2/", false, nil},
+	{"/img-:id<int>.png", "/img-42.png", true, map[string]string{"id": "42"}},
+	{"/img-:id<int>.png", "/img-x.png", false, nil},
+	// optional variables
+	{"/archive/:year/:month?", "/archive/2016/05", true, map[string]string{"year": "2016", "month": "05"}},
+	{"/archive/:year/:month?", "/archive/2016", true, map[string]string{"year": "2016", "month": ""}},
+	{"/archive/:year/:month?", "/archive/2016/", false, nil},
+	{"/archive/:year/:month?", "/archive", false, nil},
+	{"/:page?", "/", true, map[string]string{"page": ""}},
+	{"/:page?", "/2", true, map[string]string{"page": "2"}},
 }
 
 func TestMatch(t *testing.T) {
+	for _, v := range matchTests {
+		var vars map[string]string
+		r := New()
+		r.Route(v.pattern).Handle(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			vars = map[string]string{}
+			for k := range v.vars {
+				vars[k] = muxy.Var(req, k)
+			}
+		}))
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest("GET", v.path, nil))
+		if match := vars != nil; match != v.match {
+			t.Errorf("%q %q: expected match %v; got %v", v.pattern, v.path, v.match, match)
+			continue
+		}
+		for k, value := range v.vars {
+			if vars[k] != value {
+				t.Errorf("%q %q: expected %s=%q; got %q", v.pattern, v.path, k, value, vars[k])
+			}
+		}
+	}
+}
+
+func TestMatchBadRequest(t *testing.T) {
+	r := New()
+	r.Route("/:foo").Handle(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {}))
+	for _, path := range []string{"/%2x", "/%2", "/foo%"} {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", "/", nil)
+		req.URL.RawPath = path
+		req.URL.Path = "/"
+		r.ServeHTTP(w, req)
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("%q: expected status %d; got %d", path, http.StatusBadRequest, w.Code)
+		}
+	}
+}
+
+func TestMatchConstraints(t *testing.T) {
+	r := New()
+	for _, pattern := range []string{
+		"/users/:id<int>",
+		"/users/:name",
+		"/posts/:slug<regex [a-z-]+>/edit",
+		"/items/:uuid<uuid>",
+	} {
+		pattern := pattern
+		r.Route(pattern).Handle(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			w.Write([]byte(pattern))
+		}))
+	}
+	for path, pattern := range map[string]string{
+		"/users/42":               "/users/:id<int>",
+		"/users/-7":               "/users/:id<int>",
+		"/users/bob":              "/users/:name",
+		"/users/4x2":              "/users/:name",
+		"/posts/hello-world/edit": "/posts/:slug<regex [a-z-]+>/edit",
+		"/posts/Hello/edit":       "",
+		"/items/f81d4fae-7dec-11d0-a765-00a0c91e6bf6": "/items/:uuid<uuid>",
+		"/items/f81d4fae-7dec-11d0-a765":              "",
+	} {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		if pattern == "" {
+			if w.Code != http.StatusNotFound {
+				t.Errorf("%q: expected status %d; got %d", path, http.StatusNotFound, w.Code)
+			}
+		} else if w.Body.String() != pattern {
+			t.Errorf("%q: expected match for %q; got %q", path, pattern, w.Body.String())
+		}
+	}
+}
+
+func TestMatchPrecedence(t *testing.T) {
+	r := New()
+	for _, pattern := range []string{
+		"/files/:name",
+		"/files/:name.json",
+		"/files/:id<int>.json",
+		"/files/data.json",
+		"/files/:name.tar.gz",
+		"/files/*",
+	} {
+		pattern := pattern
+		r.Route(pattern).Handle(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			w.Write([]byte(pattern))
+		}))
+	}
+	for path, pattern := range map[string]string{
+		"/files/data.json": "/files/data.json",
+		"/files/42.json":   "/files/:id<int>.json",
+		"/files/x.json":    "/files/:name.json",
+		"/files/x.tar.gz":  "/files/:name.tar.gz",
+		"/files/x.gz":      "/files/:name",
+	} {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		if w.Body.String() != pattern {
+			t.Errorf("%q: expected match for %q; got %q", path, pattern, w.Body.String())
+		}
+	}
+}
+
+func TestMatchBacktracking(t *testing.T) {
+	r := New()
+	for _, pattern := range []string{
+		"/users/new/edit",
+		"/users/:id/delete",
+		"/users/:id<int>/posts/:post",
+		"/users/:name/posts/latest",
+		"/files/:name.json/meta",
+		"/files/:name/raw",
+		"/files/*",
+		"/a/b/c/",
+		"/a/:x/:y/d",
+		"/a/*",
+	} {
+		pattern := pattern
+		r.Route(pattern).Handle(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			w.Write([]byte(pattern))
+		}))
+	}
+	r.Route("/hosts/:id").Host("example.com").Handle(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte("host"))
+	}))
+	r.Route("/hosts/*").Handle(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte("any host"))
+	}))
+	for path, pattern := range map[string]string{
+		// static > variable
+		"/users/new/edit":   "/users/new/edit",
+		"/users/new/delete": "/users/:id/delete",
+		// constrained variable > unconstrained variable
+		"/users/42/posts/latest":  "/users/:id<int>/posts/:post",
+		"/users/bob/posts/latest": "/users/:name/posts/latest",
+		// variable with literals > variable > wildcard
+		"/files/x.json/meta": "/files/:name.json/meta",
+		"/files/x.json/raw":  "/files/:name/raw",
+		"/files/x.json/more": "/files/*",
+		// deep dead ends
+		"/a/b/c/":  "/a/b/c/",
+		"/a/b/c/d": "/a/:x/:y/d",
+		"/a/b/c/e": "/a/*",
+		"/a/b/c":   "/a/*",
+		// request conditions
+		"http://example.com/hosts/1": "host",
+		"http://example.org/hosts/1": "any host",
+	} {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		if w.Body.String() != pattern {
+			t.Errorf("%q: expected match for %q; got %q", path, pattern, w.Body.String())
+		}
+	}
+}
+
+func TestRouteOptional(t *testing.T) {
+	r := New()
+	r.Route("/archive/:year/:month?")
+	for _, pattern := range []string{"/archive/:y", "/archive/:y/:m"} {
+		if _, err := r.TryRoute(pattern); err == nil {
+			t.Errorf("%q: expected duplicated route error", pattern)
+		}
+	}
+}
+
+func TestTrailingSlash(t *testing.T) {
+	tests := []struct {
+		option func(*matcher)
+		path   string
+		code   int
+		loc    string
+	}{
+		{nil, "/foo/", http.StatusNotFound, ""},
+		{nil, "/bar", http.StatusNotFound, ""},
+		{RedirectTrailingSlash(http.StatusMovedPermanently), "/foo/", http.StatusMovedPermanently, "/foo"},
+		{RedirectTrailingSlash(http.StatusPermanentRedirect), "/bar?x=1", http.StatusPermanentRedirect, "/bar/?x=1"},
+		{RedirectTrailingSlash(http.StatusMovedPermanently), "/a%20b/x/", http.StatusMovedPermanently, "/a%20b/x"},
+		{RedirectTrailingSlash(http.StatusMovedPermanently), "/baz/", http.StatusNotFound, ""},
+		{MatchTrailingSlash(), "/foo/", http.StatusOK, ""},
+		{MatchTrailingSlash(), "/bar", http.StatusOK, ""},
+		{MatchTrailingSlash(), "/a%20b/x/", http.StatusOK, ""},
+	}
+	for _, v := range tests {
+		var r *muxy.Router
+		if v.option == nil {
+			r = New()
+		} else {
+			r = New(v.option)
+		}
+		h := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {})
+		r.Route("/foo").Handle(h)
+		r.Route("/bar/").Handle(h)
+		r.Route("/:name/x").Handle(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			if name := muxy.Var(req, "name"); name != "a b" {
+				t.Errorf("expected name %q; got %q", "a b", name)
+			}
+		}))
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest("GET", v.path, nil))
+		if w.Code != v.code {
+			t.Errorf("%q: expected status %d; got %d", v.path, v.code, w.Code)
+		}
+		if loc := w.Header().Get("Location"); loc != v.loc {
+			t.Errorf("%q: expected location %q; got %q", v.path, v.loc, loc)
+		}
+	}
+}
+
+func TestCanonicalPath(t *testing.T) {
+	tests := []struct {
+		path string
+		code int
+		loc  string
+	}{
+		{"/foo/bar", http.StatusOK, ""},
+		{"/foo/../foo/bar", http.StatusMovedPermanently, "/foo/bar"},
+		{"/foo//bar?x=1&y=2", http.StatusMovedPermanently, "/foo/bar?x=1&y=2"},
+		{"/foo/./bar/", http.StatusMovedPermanently, "/foo/bar"},
+		{"/foo/./x%20y", http.StatusMovedPermanently, "/foo/x%20y"},
+		{"/baz/../qux", http.StatusNotFound, ""},
+	}
+	r := New(RedirectCanonicalPath(http.StatusMovedPermanently), RedirectTrailingSlash(http.StatusMovedPermanently))
+	r.Route("/foo/:name").Handle(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {}))
+	for _, v := range tests {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest("GET", v.path, nil))
+		if w.Code != v.code {
+			t.Errorf("%q: expected status %d; got %d", v.path, v.code, w.Code)
+		}
+		if loc := w.Header().Get("Location"); loc != v.loc {
+			t.Errorf("%q: expected location %q; got %q", v.path, v.loc, loc)
+		}
+	}
+}
+
+func TestCaseInsensitive(t *testing.T) {
+	r := New(CaseInsensitive())
+	r.Route("/users/new").Handle(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte("new"))
+	}))
+	r.Route("/users/:name/posts").Handle(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte(muxy.Var(req, "name")))
+	}))
+	r.Route("/straße/ǅ").Handle(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte("dz"))
+	}))
+	r.Route("/files/:name.json").Handle(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte("json"))
+	}))
+	for path, body := range map[string]string{
+		"/users/new":       "new",
+		"/USERS/New":       "new",
+		"/Users/Bob/Posts": "Bob",
+		"/STRASSE/ǆ":       "",
+		"/Straße/ǆ":        "dz",
+		"/files/A.json":    "json",
+		"/files/A.JSON":    "",
+	} {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		if body == "" {
+			if w.Code != http.StatusNotFound {
+				t.Errorf("%q: expected status %d; got %d", path, http.StatusNotFound, w.Code)
+			}
+		} else if w.Body.String() != body {
+			t.Errorf("%q: expected body %q; got %q", path, body, w.Body.String())
+		}
+	}
+	if _, err := r.TryRoute("/Users/NEW"); err == nil {
+		t.Errorf("expected duplicated route error")
+	}
+
+	r = New(CaseInsensitive(), RedirectCanonicalPath(http.StatusMovedPermanently))
+	r.Route("/Users/:name/posts").Handle(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {}))
+	for path, loc := range map[string]string{
+		"/Users/Bob/posts":     "",
+		"/users/Bob/POSTS?x=1": "/Users/Bob/posts?x=1",
+		"/USERS/a%20b/./posts": "/Users/a%20b/posts",
+	} {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		if w.Header().Get("Location") != loc {
+			t.Errorf("%q: expected location %q; got %q", path, loc, w.Header().Get("Location"))
+		}
+	}
+}
+
+func TestWildcard(t *testing.T) {
+	var segs []string
+	r := New()
+	r.Route("/files/*path").Handle(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		segs = Wildcard(req)
+	}))
+	r.Route("/other").Handle(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		segs = Wildcard(req)
+	}))
+	for path, expected := range map[string][]string{
+		"/files/a%2Fb/c": {"a/b", "c"},
+		"/files/a/":      {"a", ""},
+		"/files/":        {},
+		"/other":         nil,
+	} {
+		segs = []string{"unset"}
+		r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", path, nil))
+		if !equalStrings(segs, expected) || (segs == nil) != (expected == nil) {
+			t.Errorf("%q: expected %q; got %q", path, expected, segs)
+		}
+	}
+}
+
+func TestRegisterConstraint(t *testing.T) {
+	RegisterConstraint("even", func(arg string) (Constraint, error) {
+		return func(v string) bool {
+			return len(v) > 0 && strings.IndexByte("02468", v[len(v)-1]) >= 0
+		}, nil
+	})
+	r := New()
+	r.Route("/:n<even>").Name("even")
+	if u := r.URL("even", "n", "12"); u != "/12" {
+		t.Errorf("expected %q; got %q", "/12", u)
+	}
+	p, err := parse("/:n<even>")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := newPattern(p).build("n", "13"); err == nil {
+		t.Errorf("expected error building odd value")
+	}
+}
+
+type buildTest struct {
+	pattern string
+	vars    []string
+	url     string
+}
+
+var buildTests = []buildTest{
+	{"/", nil, "/"},
+	{"/foo/", nil, "/foo/"},
+	{"/:foo/bar", []string{"foo", "x"}, "/x/bar"},
+	{"/:foo/:bar/", []string{"bar", "y", "foo", "x"}, "/x/y/"},
+	{"/foo/*", []string{"*", "x/y"}, "/foo/x/y"},
+	{"/foo%2Fbar/:baz", []string{"baz", "x"}, "/foo%2Fbar/x"},
+	// encoded variables
+	{"/:foo", []string{"foo", "a b/c"}, "/a%20b%2Fc"},
+	{"/:foo", []string{"foo", ".."}, "/%2E%2E"},
+	{"/:foo", []string{"foo", "世界"}, "/%E4%B8%96%E7%95%8C"},
+	{"/foo/*", []string{"*", "a b/c?/"}, "/foo/a%20b/c%3F/"},
+	{"/foo/*", []string{"*", "./x"}, "/foo/%2E/x"},
+	{"/foo/*", []string{"*", ""}, "/foo/"},
+	{"/foo/*path", []string{"path", "x/y"}, "/foo/x/y"},
+	// typed variables
+	{"/:id<int>", []string{"id", "42"}, "/42"},
+	{"/:slug<regex [a-z ]+>", []string{"slug", "a b"}, "/a%20b"},
+	// variables with literals
+	{"/files/:name.json", []string{"name", "a b"}, "/files/a%20b.json"},
+	{"/v:version/", []string{"version", "2"}, "/v2/"},
+	{"/.:name", []string{"name", "."}, "/%2E%2E"},
+	// optional variables
+	{"/archive/:year/:month?", []string{"year", "2016", "month", "05"}, "/archive/2016/05"},
+	{"/archive/:year/:month?", []string{"year", "2016"}, "/archive/2016"},
+	{"/:page?", nil, "/"},
+	// build errors
+	{"/:foo", nil, ""},
+	{"/:foo", []string{"bar", "x"}, ""},
+	{"/:foo", []string{"foo", ""}, ""},
+	{"/foo/*", []string{"*", "x//y"}, ""},
+	{"/foo/*path", []string{"*", "x"}, ""},
+	{"/foo/*", []string{"*", "/x"}, ""},
+	{"/:id<int>", []string{"id", "x"}, ""},
+	{"/:slug<regex [a-z-]+>", []string{"slug", "A"}, ""},
+	{"/archive/:year/:month?", []string{"month", "05"}, ""},
+	{"/:a?/:b?", []string{"b", "x"}, ""},
 }
 
 func TestBuild(t *testing.T) {
+	for _, v := range buildTests {
+		p, err := parse(v.pattern)
+		if err != nil {
+			t.Fatal(err)
+		}
+		u, err := newPattern(p).build(v.vars...)
+		if (err == nil) != (v.url != "") {
+			t.Errorf("%q %v: expected %q; got %q, %v", v.pattern, v.vars, v.url, u, err)
+		} else if u != v.url {
+			t.Errorf("%q %v: expected %q; got %q", v.pattern, v.vars, v.url, u)
+		}
+	}
 }
 
-func equalParts(p1, p2 []string) bool {
+func equalParts(p1 []string, p2 []segment) bool {
 	if len(p1) != len(p2) {
 		return false
 	}
 	for k, v := range p1 {
-		if v != p2[k] {
+		if v != p2[k].String() {
+			return false
+		}
+	}
+	return true
+}
+
+func TestBuildRoundTrip(t *testing.T) {
+	for _, v := range buildTests {
+		if v.url == "" {
+			continue
+		}
+		var vars []string
+		r := New()
+		r.Route(v.pattern).Name("route").Handle(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			vars = make([]string, len(v.vars))
+			for i := 0; i < len(v.vars); i += 2 {
+				vars[i], vars[i+1] = v.vars[i], muxy.Var(req, v.vars[i])
+			}
+		}))
+		u := r.URL("route", v.vars...)
+		r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", u, nil))
+		if vars == nil {
+			t.Errorf("%q %v: built URL %q does not match", v.pattern, v.vars, u)
+		} else if !equalStrings(vars, v.vars) {
+			t.Errorf("%q %q: expected variables %v; got %v", v.pattern, u, v.vars, vars)
+		}
+	}
+}
+
+func equalStrings(s1, s2 []string) bool {
+	if len(s1) != len(s2) {
+		return false
+	}
+	for k, v := range s1 {
+		if v != s2[k] {
 			return false
 		}
 	}
@@ -0,0 +1,229 @@
+package mregex
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/gorilla/muxy"
+	"github.com/gorilla/muxy/encoder"
+)
+
+// UseEncodedPath makes the matcher match routes against the escaped URL
+// path, so that "%2F" is not taken as a path separator. Variable values are
+// decoded before being stored in the request context.
+func UseEncodedPath() func(*matcher) {
+	return func(m *matcher) {
+		m.encoded = true
+	}
+}
+
+// New returns a new muxy.Router that matches URL paths against regular
+// expressions, using gorilla/mux style templates such as:
+//
+//     /articles/{category}/{id:[0-9]+}
+//     /archive/{year:[0-9]{4}}-{month:[0-9]{2}}.html
+//
+// Variables without a regular expression match any characters except a
+// slash. Routes are tried in registration order.
+func New(options ...func(*matcher)) *muxy.Router {
+	m := &matcher{
+		patterns: map[*muxy.Route]*pattern{},
+	}
+	for _, o := range options {
+		o(m)
+	}
+	return muxy.New(m)
+}
+
+type matcher struct {
+	routes   []*muxy.Route
+	patterns map[*muxy.Route]*pattern
+	encoded  bool
+}
+
+// Config returns the matcher options; see muxy.ConfigMatcher.
+func (m *matcher) Config() interface{} {
+	return m.encoded
+}
+
+func (m *matcher) Route(tpl string) (*muxy.Route, error) {
+	p, err := parse(tpl)
+	if err != nil {
+		return nil, err
+	}
+	for _, r := range m.routes {
+		// Routes with host, scheme or query conditions can share a
+		// template, but nothing can follow a route without them.
+		if m.patterns[r].raw == p.raw && !r.HasConditions() {
+			return nil, fmt.Errorf("muxy: a route for the template %q or equivalent already exists: %q", tpl, r.Pattern)
+		}
+	}
+	r := &muxy.Route{}
+	m.routes = append(m.routes, r)
+	m.patterns[r] = p
+	return r, nil
+}
+
+func (m *matcher) Match(r *http.Request) (http.Handler, *http.Request) {
+	p := r.URL.Path
+	if m.encoded {
+		p = r.URL.EscapedPath()
+	}
+	p = muxy.CleanPath(p)
+	for _, route := range m.routes {
+		vars := m.patterns[route].re.FindStringSubmatch(p)
+		if vars == nil {
+			continue
+		}
+		cvars, ok := route.Matches(r)
+		if !ok {
+			continue
+		}
+		vars = vars[1:]
+		if m.encoded {
+			for i, v := range vars {
+				s, err := decodePath(v)
+				if err != nil {
+					return http.HandlerFunc(muxy.BadRequest), r
+				}
+				vars[i] = s
+			}
+		}
+		return route, m.patterns[route].setVars(r, vars, cvars)
+	}
+	return nil, r
+}
+
+func (m *matcher) Build(r *muxy.Route, vars ...string) (string, error) {
+	if p, ok := m.patterns[r]; ok {
+		return p.build(vars...)
+	}
+	return "", fmt.Errorf("muxy: route not found: %v", r)
+}
+
+// Paths returns the path template matched by the route; see
+// muxy.PathMatcher.
+func (m *matcher) Paths(r *muxy.Route) [][]muxy.PathPart {
+	p, ok := m.patterns[r]
+	if !ok {
+		return nil
+	}
+	var parts []muxy.PathPart
+	for i, k := range p.keys {
+		v := muxy.PathPart{Name: string(k)}
+		if p.pats[i] != defaultPattern {
+			v.Pattern = p.pats[i]
+		}
+		if p.parts[i] != "" {
+			parts = append(parts, muxy.PathPart{Literal: p.parts[i]})
+		}
+		parts = append(parts, v)
+	}
+	if lit := p.parts[len(p.keys)]; lit != "" {
+		parts = append(parts, muxy.PathPart{Literal: lit})
+	}
+	return [][]muxy.PathPart{parts}
+}
+
+// -----------------------------------------------------------------------------
+
+// pattern is a parsed route template.
+type pattern struct {
+	raw   string           // cleaned template
+	re    *regexp.Regexp   // matches the whole path
+	parts []string         // literal parts; one more than keys
+	keys  []muxy.Variable  // variable names
+	pats  []string         // variable regexps, as given or the default
+	vres  []*regexp.Regexp // variable regexps, to validate built values
+}
+
+// defaultPattern is the regexp for variables which don't define one.
+const defaultPattern = "[^/]+"
+
+// parse parses a route template, with variables in the form "{name}" or
+// "{name:regexp}".
+func parse(tpl string) (*pattern, error) {
+	t, err := muxy.ParseTemplate(tpl, defaultPattern)
+	if err != nil {
+		return nil, err
+	}
+	if t.Parts[0] == "" || t.Parts[0][0] != '/' {
+		return nil, fmt.Errorf("muxy: template must start with a slash: %q", tpl)
+	}
+	p := &pattern{re: t.Regexp, parts: t.Parts, pats: t.Patterns, vres: t.Regexps}
+	raw := new(bytes.Buffer)
+	for i, name := range t.Names {
+		p.keys = append(p.keys, muxy.Variable(name))
+		raw.WriteString(t.Parts[i] + "{" + t.Patterns[i] + "}")
+	}
+	raw.WriteString(t.Parts[len(t.Names)])
+	p.raw = raw.String()
+	return p, nil
+}
+
+// setVars sets the route variables in the request context. Host and query
+// variables, passed in cvars as key/value pairs, are set as well.
+func (p *pattern) setVars(r *http.Request, vars []string, cvars []string) *http.Request {
+	if len(p.keys) == 0 && len(cvars) == 0 {
+		return r
+	}
+	pairs := make([]string, 0, len(p.keys)*2+len(cvars))
+	for i, k := range p.keys {
+		pairs = append(pairs, string(k), vars[i])
+	}
+	return muxy.WithVars(r, append(pairs, cvars...)...)
+}
+
+// build returns a URL path for the pattern using the given variables, which
+// are passed as key/value pairs. Values must match the variable regexps,
+// and are percent encoded except for slashes.
+func (p *pattern) build(vars ...string) (string, error) {
+	b := new(bytes.Buffer)
+Loop:
+	for i, k := range p.keys {
+		b.WriteString(p.parts[i])
+		for j := 0; j < len(vars); j += 2 {
+			if vars[j] != string(k) {
+				continue
+			}
+			if !p.vres[i].MatchString(vars[j+1]) {
+				return "", &muxy.VarError{Name: string(k), Err: fmt.Errorf("muxy: value %q does not match %q for variable", vars[j+1], p.vres[i])}
+			}
+			b.WriteString(encodePath(vars[j+1]))
+			continue Loop
+		}
+		return "", &muxy.VarError{Name: string(k), Err: muxy.ErrMissingVar}
+	}
+	b.WriteString(p.parts[len(p.keys)])
+	if len(p.keys)*2 != len(vars) {
+		return "", fmt.Errorf("muxy: expected %d arguments, got %d: %v", len(p.keys)*2, len(vars), vars)
+	}
+	return b.String(), nil
+}
+
+// -----------------------------------------------------------------------------
+
+// encodePath percent encodes each slash-separated segment of s.
+func encodePath(s string) string {
+	segs := strings.Split(s, "/")
+	for i, seg := range segs {
+		segs[i] = encoder.EncodePathSegment(seg)
+	}
+	return strings.Join(segs, "/")
+}
+
+// decodePath decodes each slash-separated segment of s.
+func decodePath(s string) (string, error) {
+	segs := strings.Split(s, "/")
+	for i, seg := range segs {
+		v, err := encoder.DecodePathSegment(seg)
+		if err != nil {
+			return "", err
+		}
+		segs[i] = v
+	}
+	return strings.Join(segs, "/"), nil
+}
@@ -0,0 +1,179 @@
+package mregex
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/muxy"
+)
+
+var parseErrors = []string{
+	"/{id",              // unbalanced braces
+	"/id}",              // unbalanced braces
+	"/{}",               // empty variable name
+	"/{id:}",            // empty regexp
+	"/{id:[a-z}",        // invalid regexp
+	"/{id:(a|b)}",       // capturing group
+	"/{id}/{id}",        // duplicated variable
+	"{id}",              // no leading slash
+	"/{id:a}{id:b}.txt", // duplicated variable
+}
+
+func TestParse(t *testing.T) {
+	for _, tpl := range parseErrors {
+		if _, err := parse(tpl); err == nil {
+			t.Errorf("%q: expected parsing error", tpl)
+		}
+	}
+	p, err := parse("/{year:[0-9]{4}}-{month}.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.raw != "/{[0-9]{4}}-{[^/]+}.html" {
+		t.Errorf("unexpected normalized template %q", p.raw)
+	}
+}
+
+type matchTest struct {
+	tpl   string
+	path  string
+	match bool
+	vars  map[string]string
+}
+
+var matchTests = []matchTest{
+	{"/", "/", true, nil},
+	{"/foo", "/foo/", false, nil},
+	{"/foo/{bar}", "/foo/x", true, map[string]string{"bar": "x"}},
+	{"/foo/{bar}", "/foo/x/y", false, nil},
+	{"/foo/{bar:.+}", "/foo/x/y", true, map[string]string{"bar": "x/y"}},
+	{"/{id:[0-9]+}", "/42", true, map[string]string{"id": "42"}},
+	{"/{id:[0-9]+}", "/4x", false, nil},
+	{"/{year:[0-9]{4}}-{month:[0-9]{2}}.html", "/2016-05.html", true, map[string]string{"year": "2016", "month": "05"}},
+	{"/{year:[0-9]{4}}-{month:[0-9]{2}}.html", "/2016-5.html", false, nil},
+	{"/v{version}/{path:.*}", "/v2/", true, map[string]string{"version": "2", "path": ""}},
+	{"/files/{name}", "/files/a%20b", true, map[string]string{"name": "a b"}},
+	{"/a.b", "/axb", false, nil},
+	{"/foo/{bar}", "/x/../foo//y", true, map[string]string{"bar": "y"}},
+}
+
+func TestMatch(t *testing.T) {
+	for _, v := range matchTests {
+		var vars map[string]string
+		r := New()
+		r.Route(v.tpl).Handle(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			vars = map[string]string{}
+			for k := range v.vars {
+				vars[k] = muxy.Var(req, k)
+			}
+		}))
+		r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", v.path, nil))
+		if match := vars != nil; match != v.match {
+			t.Errorf("%q %q: expected match %v; got %v", v.tpl, v.path, v.match, match)
+			continue
+		}
+		for k, value := range v.vars {
+			if vars[k] != value {
+				t.Errorf("%q %q: expected %s=%q; got %q", v.tpl, v.path, k, value, vars[k])
+			}
+		}
+	}
+}
+
+func TestMatchOrder(t *testing.T) {
+	r := New()
+	for _, tpl := range []string{"/users/{id:[0-9]+}", "/users/{name}", "/users/new"} {
+		tpl := tpl
+		r.Route(tpl).Handle(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			w.Write([]byte(tpl))
+		}))
+	}
+	for path, tpl := range map[string]string{
+		"/users/42":  "/users/{id:[0-9]+}",
+		"/users/bob": "/users/{name}",
+		"/users/new": "/users/{name}",
+	} {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		if w.Body.String() != tpl {
+			t.Errorf("%q: expected match for %q; got %q", path, tpl, w.Body.String())
+		}
+	}
+	if _, err := r.TryRoute("/users/{other:[0-9]+}"); err == nil {
+		t.Errorf("expected error registering an equivalent template")
+	}
+}
+
+func TestUseEncodedPath(t *testing.T) {
+	var name string
+	r := New(UseEncodedPath())
+	r.Route("/files/{name}").Handle(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		name = muxy.Var(req, "name")
+	}))
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/files/a%2Fb%20c", nil))
+	if name != "a/b c" {
+		t.Errorf("expected %q; got %q", "a/b c", name)
+	}
+}
+
+type buildTest struct {
+	tpl  string
+	vars []string
+	url  string
+}
+
+var buildTests = []buildTest{
+	{"/", nil, "/"},
+	{"/foo/{bar}", []string{"bar", "x"}, "/foo/x"},
+	{"/foo/{bar}", []string{"bar", "a b"}, "/foo/a%20b"},
+	{"/foo/{bar:.+}", []string{"bar", "x/y z"}, "/foo/x/y%20z"},
+	{"/{year:[0-9]{4}}-{month:[0-9]{2}}.html", []string{"month", "05", "year", "2016"}, "/2016-05.html"},
+	// build errors
+	{"/foo/{bar}", nil, ""},
+	{"/foo/{bar}", []string{"bar", "x/y"}, ""},
+	{"/{id:[0-9]+}", []string{"id", "x"}, ""},
+	{"/{id:[0-9]+}", []string{"id", "1", "other", "2"}, ""},
+}
+
+func TestBuild(t *testing.T) {
+	for _, v := range buildTests {
+		p, err := parse(v.tpl)
+		if err != nil {
+			t.Fatal(err)
+		}
+		u, err := p.build(v.vars...)
+		if (err == nil) != (v.url != "") {
+			t.Errorf("%q %v: expected %q; got %q, %v", v.tpl, v.vars, v.url, u, err)
+		} else if u != v.url {
+			t.Errorf("%q %v: expected %q; got %q", v.tpl, v.vars, v.url, u)
+		}
+	}
+}
+
+func TestBuildRoundTrip(t *testing.T) {
+	for _, v := range buildTests {
+		if v.url == "" {
+			continue
+		}
+		var vars map[string]string
+		r := New()
+		r.Route(v.tpl).Name("route").Handle(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			vars = map[string]string{}
+			for i := 0; i < len(v.vars); i += 2 {
+				vars[v.vars[i]] = muxy.Var(req, v.vars[i])
+			}
+		}))
+		u := r.URL("route", v.vars...)
+		r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", u, nil))
+		if vars == nil {
+			t.Errorf("%q %v: built URL %q does not match", v.tpl, v.vars, u)
+			continue
+		}
+		for i := 0; i < len(v.vars); i += 2 {
+			if vars[v.vars[i]] != v.vars[i+1] {
+				t.Errorf("%q %q: expected %s=%q; got %q", v.tpl, u, v.vars[i], v.vars[i+1], vars[v.vars[i]])
+			}
+		}
+	}
+}
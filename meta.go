@@ -0,0 +1,131 @@
+package muxy
+
+import "net/http"
+
+// Metadata holds declarative annotations for a route, for use by
+// middleware and tooling such as documentation generators.
+type Metadata struct {
+	// Description holds a human readable description of the route.
+	Description string
+	// Tags holds the route tags, without duplicates.
+	Tags []string
+	// Deprecated indicates whether the route is deprecated.
+	Deprecated bool
+	// Values maps metadata keys to their values.
+	Values map[string]interface{}
+}
+
+// meta is the metadata set in a router or route.
+type meta struct {
+	description string
+	tags        []string
+	deprecated  bool
+	values      map[string]interface{}
+}
+
+// set sets a metadata value.
+func (m *meta) set(key string, value interface{}) {
+	if m.values == nil {
+		m.values = map[string]interface{}{}
+	}
+	m.values[key] = value
+}
+
+// merge adds the metadata to md, overriding its description and values.
+func (m *meta) merge(md *Metadata) {
+	if m.description != "" {
+		md.Description = m.description
+	}
+	for _, t := range m.tags {
+		if !contains(md.Tags, t) {
+			md.Tags = append(md.Tags, t)
+		}
+	}
+	md.Deprecated = md.Deprecated || m.deprecated
+	for k, v := range m.values {
+		if md.Values == nil {
+			md.Values = map[string]interface{}{}
+		}
+		md.Values[k] = v
+	}
+}
+
+// Meta sets a metadata value for the routes registered in the router.
+//
+// Metadata is inherited by groups, and routes can override values; see
+// Route.Metadata.
+func (r *Router) Meta(key string, value interface{}) *Router {
+	r.meta.set(key, value)
+	return r
+}
+
+// Tag adds tags to the routes registered in the router.
+func (r *Router) Tag(tags ...string) *Router {
+	r.meta.tags = append(r.meta.tags, tags...)
+	return r
+}
+
+// Deprecate marks the routes registered in the router as deprecated.
+func (r *Router) Deprecate() *Router {
+	r.meta.deprecated = true
+	return r
+}
+
+// Meta sets a metadata value for the route.
+func (r *Route) Meta(key string, value interface{}) *Route {
+	r.meta.set(key, value)
+	return r
+}
+
+// Tag adds tags to the route.
+func (r *Route) Tag(tags ...string) *Route {
+	r.meta.tags = append(r.meta.tags, tags...)
+	return r
+}
+
+// Describe sets the route description.
+func (r *Route) Describe(description string) *Route {
+	r.meta.description = description
+	return r
+}
+
+// Deprecate marks the route as deprecated.
+func (r *Route) Deprecate() *Route {
+	r.meta.deprecated = true
+	return r
+}
+
+// Metadata returns the route metadata, combining the one set in its router
+// and the router's ancestors, starting with the main router, and the one
+// set in the route. Tags are accumulated, and later values override
+// earlier ones with the same key.
+//
+// In middleware and handlers, the metadata of the matched route is
+// available through CurrentRoute:
+//
+//     if muxy.CurrentRoute(r).Metadata().Values["auth"] == "admin" {
+//         // ...
+//     }
+func (r *Route) Metadata() Metadata {
+	var groups []*Router
+	for g := r.Router; g != nil; g = g.parent {
+		groups = append(groups, g)
+	}
+	var md Metadata
+	for i := len(groups) - 1; i >= 0; i-- {
+		groups[i].meta.merge(&md)
+	}
+	r.meta.merge(&md)
+	return md
+}
+
+// MetaValue returns the metadata value with the given key for the route
+// that matched the request, and whether it was set.
+func MetaValue(r *http.Request, key string) (interface{}, bool) {
+	route := CurrentRoute(r)
+	if route == nil {
+		return nil, false
+	}
+	v, ok := route.Metadata().Values[key]
+	return v, ok
+}
@@ -0,0 +1,272 @@
+// Package openapi generates OpenAPI 3 documents from the routes registered
+// in a muxy.Router.
+//
+// Path templates are taken from the route matcher, which must implement
+// muxy.PathMatcher, as the mpath and mregex matchers do: variables become
+// path parameters, and wildcards become a parameter named after the
+// wildcard, or "wildcard" if unnamed. Typed mpath variables such as
+// ":id<int>" and regexp variables such as "{id:[0-9]+}" set the parameter
+// schema.
+//
+// Operations are taken from the route handlers and metadata: the route
+// description, tags and deprecation flag are used as is, and request and
+// response schemas can be attached with the RequestSchema and
+// ResponseSchema metadata keys:
+//
+//     r.Route("/users/:id<int>").Name("user").
+//         Describe("Returns a user.").
+//         Meta(openapi.ResponseSchema, openapi.Schema{"$ref": "#/components/schemas/User"}).
+//         Get(showUser)
+package openapi
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/muxy"
+	"gopkg.in/yaml.v3"
+)
+
+// Metadata keys used to describe operations.
+const (
+	// RequestSchema is the metadata key for the schema of JSON request
+	// bodies. Values must be of type Schema.
+	RequestSchema = "openapi.request"
+	// ResponseSchema is the metadata key for the schema of successful JSON
+	// responses. Values must be of type Schema.
+	ResponseSchema = "openapi.response"
+)
+
+// Schema is an OpenAPI schema object, such as {"type": "string"}.
+type Schema map[string]interface{}
+
+// Info holds the document metadata.
+type Info struct {
+	Title       string
+	Version     string
+	Description string
+}
+
+// Document is an OpenAPI document. It is a tree of maps, slices and
+// scalars, which can be modified before encoding.
+type Document map[string]interface{}
+
+// anyMethods are the operations generated for handlers registered for any
+// method.
+var anyMethods = []string{"GET", "POST", "PUT", "PATCH", "DELETE"}
+
+// operations are the methods OpenAPI has operations for.
+var operations = []string{"GET", "PUT", "POST", "DELETE", "OPTIONS", "HEAD", "PATCH", "TRACE"}
+
+// Generate returns an OpenAPI 3 document for the routes registered in the
+// given router, in registration order. Routes without handlers are
+// skipped, as well as methods OpenAPI has no operation for. As with
+// Router.Walk, routes of routers mounted with a different matcher are not
+// included.
+func Generate(r *muxy.Router, info Info) Document {
+	paths := map[string]interface{}{}
+	r.Walk(func(route *muxy.Route, methods []string) error {
+		ops := expand(methods)
+		variants := convert(route)
+		for i, p := range variants {
+			item, _ := paths[p.path].(map[string]interface{})
+			if item == nil {
+				item = map[string]interface{}{}
+				paths[p.path] = item
+			}
+			if len(p.params) > 0 && item["parameters"] == nil {
+				item["parameters"] = p.params
+			}
+			id := ""
+			if r.NamedRoutes[route.Noun] == route {
+				id = route.Noun
+				if i > 0 {
+					id += "_" + strings.Join(p.names[len(variants[0].names):], "_")
+				}
+			}
+			for _, m := range ops {
+				opID := id
+				if opID != "" && len(ops) > 1 {
+					opID += "_" + strings.ToLower(m)
+				}
+				if op := strings.ToLower(m); item[op] == nil {
+					item[op] = operation(route, m, opID)
+				}
+			}
+		}
+		return nil
+	})
+	inf := map[string]interface{}{
+		"title":   info.Title,
+		"version": info.Version,
+	}
+	if info.Description != "" {
+		inf["description"] = info.Description
+	}
+	return Document{
+		"openapi": "3.0.3",
+		"info":    inf,
+		"paths":   paths,
+	}
+}
+
+// expand returns the OpenAPI methods for the given route methods.
+func expand(methods []string) []string {
+	var ms []string
+	for _, m := range methods {
+		if m == "*" {
+			ms = append(ms, anyMethods...)
+			continue
+		}
+		for _, op := range operations {
+			if m == op {
+				ms = append(ms, m)
+			}
+		}
+	}
+	return ms
+}
+
+// operation returns the operation object for a route method.
+func operation(route *muxy.Route, method, id string) map[string]interface{} {
+	md := route.Metadata()
+	op := map[string]interface{}{}
+	if id != "" {
+		op["operationId"] = id
+	}
+	if md.Description != "" {
+		op["description"] = md.Description
+	}
+	if len(md.Tags) > 0 {
+		op["tags"] = md.Tags
+	}
+	if md.Deprecated {
+		op["deprecated"] = true
+	}
+	if s, ok := md.Values[RequestSchema].(Schema); ok && method != "GET" && method != "HEAD" {
+		op["requestBody"] = map[string]interface{}{
+			"required": true,
+			"content":  jsonContent(s),
+		}
+	}
+	resp := map[string]interface{}{"description": http.StatusText(http.StatusOK)}
+	if s, ok := md.Values[ResponseSchema].(Schema); ok {
+		resp["content"] = jsonContent(s)
+	}
+	op["responses"] = map[string]interface{}{"200": resp}
+	return op
+}
+
+func jsonContent(s Schema) map[string]interface{} {
+	return map[string]interface{}{
+		"application/json": map[string]interface{}{"schema": s},
+	}
+}
+
+// JSON returns the document encoded as indented JSON.
+func (d Document) JSON() ([]byte, error) {
+	return json.MarshalIndent(d, "", "  ")
+}
+
+// YAML returns the document encoded as YAML. Mapping keys are sorted.
+func (d Document) YAML() ([]byte, error) {
+	return yaml.Marshal(map[string]interface{}(d))
+}
+
+// Handler returns a handler that serves the document for the given router,
+// generated on each request so that it reflects routes registered later.
+// It serves YAML if the request has a "format=yaml" query value, and JSON
+// otherwise.
+func Handler(r *muxy.Router, info Info) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		d := Generate(r, info)
+		encode, ctype := d.JSON, "application/json"
+		if req.URL.Query().Get("format") == "yaml" {
+			encode, ctype = d.YAML, "application/yaml"
+		}
+		b, err := encode()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", ctype)
+		w.Write(b)
+	})
+}
+
+// -----------------------------------------------------------------------------
+
+// path is an OpenAPI path template and its parameters.
+type path struct {
+	path   string
+	params []interface{}
+	names  []string
+}
+
+// convert returns the OpenAPI paths for a route, using the templates
+// reported by its matcher. Patterns with optional parts result in one
+// path for each form, as OpenAPI path parameters are always required.
+// If the matcher doesn't implement muxy.PathMatcher, the pattern is used
+// as is.
+func convert(route *muxy.Route) []path {
+	tpls := route.Paths()
+	if tpls == nil {
+		return []path{{path: route.Pattern}}
+	}
+	paths := make([]path, len(tpls))
+	for i, tpl := range tpls {
+		b := new(strings.Builder)
+		p := &paths[i]
+		for _, part := range tpl {
+			if part.Name == "" {
+				b.WriteString(part.Literal)
+				continue
+			}
+			name, desc := part.Name, ""
+			if part.Wildcard {
+				desc = "Matches the rest of the path."
+				if name == "*" {
+					name = "wildcard"
+				}
+			}
+			b.WriteString("{" + name + "}")
+			p.names = append(p.names, name)
+			p.params = append(p.params, param(name, schema(part), desc))
+		}
+		p.path = b.String()
+		if p.path == "" {
+			p.path = "/"
+		}
+	}
+	return paths
+}
+
+// schema returns the schema for a path variable.
+func schema(part muxy.PathPart) Schema {
+	s := Schema{"type": "string"}
+	switch part.Type {
+	case "int":
+		s["type"] = "integer"
+	case "uuid":
+		s["format"] = "uuid"
+	}
+	if part.Pattern != "" {
+		s["pattern"] = "^(?:" + part.Pattern + ")$"
+	}
+	return s
+}
+
+// param returns a path parameter object.
+func param(name string, schema Schema, description string) map[string]interface{} {
+	p := map[string]interface{}{
+		"name":     name,
+		"in":       "path",
+		"required": true,
+		"schema":   schema,
+	}
+	if description != "" {
+		p["description"] = description
+	}
+	return p
+}
@@ -0,0 +1,167 @@
+package openapi
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/muxy/matchers/mpath"
+	"github.com/gorilla/muxy/matchers/mregex"
+	"gopkg.in/yaml.v3"
+)
+
+var convertTests = []struct {
+	pattern string
+	paths   []string
+	params  []string
+}{
+	{"/", []string{"/"}, nil},
+	{"/users", []string{"/users"}, nil},
+	{"/users/:id", []string{"/users/{id}"}, []string{"id string"}},
+	{"/users/:id<int>/posts/", []string{"/users/{id}/posts/"}, []string{"id integer"}},
+	{"/items/:id<uuid>", []string{"/items/{id}"}, []string{"id string uuid"}},
+	{"/files/:name.json", []string{"/files/{name}.json"}, []string{"name string"}},
+	{"/static/*", []string{"/static/{wildcard}"}, []string{"wildcard string"}},
+	{"/static/*path", []string{"/static/{path}"}, []string{"path string"}},
+	{"/archive/:year/:month?", []string{"/archive/{year}", "/archive/{year}/{month}"}, []string{"year string", "month string"}},
+	{"/:page?", []string{"/", "/{page}"}, []string{"page string"}},
+	{"/posts/{id:[0-9]{2}}-{slug}", []string{"/posts/{id}-{slug}"}, []string{"id string", "slug string"}},
+	{"/files/{p:[a-z/]+}", []string{"/files/{p}"}, []string{"p string"}},
+	{"/{lang}", []string{"/{lang}"}, []string{"lang string"}},
+}
+
+func TestConvert(t *testing.T) {
+	for _, v := range convertTests {
+		r := mpath.New()
+		if strings.Contains(v.pattern, "{") {
+			r = mregex.New()
+		}
+		paths := convert(r.Route(v.pattern))
+		var got []string
+		for _, p := range paths {
+			got = append(got, p.path)
+		}
+		if strings.Join(got, " ") != strings.Join(v.paths, " ") {
+			t.Errorf("%q: expected paths %q; got %q", v.pattern, v.paths, got)
+			continue
+		}
+		var params []string
+		for _, p := range paths[len(paths)-1].params {
+			p := p.(map[string]interface{})
+			s := p["schema"].(Schema)
+			param := p["name"].(string) + " " + s["type"].(string)
+			if f, ok := s["format"]; ok {
+				param += " " + f.(string)
+			}
+			params = append(params, param)
+		}
+		if strings.Join(params, ",") != strings.Join(v.params, ",") {
+			t.Errorf("%q: expected params %q; got %q", v.pattern, v.params, params)
+		}
+	}
+}
+
+func TestGenerate(t *testing.T) {
+	h := http.NotFoundHandler()
+	r := mpath.New()
+	api := r.Group("/api").Tag("api")
+	api.Route("/users").Name("users").Get(h).Post(h).
+		Meta(RequestSchema, Schema{"$ref": "#/components/schemas/User"})
+	api.Route("/users/:id<int>").Name("user").Describe("Returns a user.").
+		Meta(ResponseSchema, Schema{"$ref": "#/components/schemas/User"}).Get(h)
+	api.Route("/legacy").Deprecate().Handle(h)
+	api.Route("/unused")
+
+	d := Generate(r, Info{Title: "Test", Version: "1.0"})
+	b, err := d.JSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var doc struct {
+		OpenAPI string
+		Paths   map[string]map[string]json.RawMessage
+	}
+	if err := json.Unmarshal(b, &doc); err != nil {
+		t.Fatal(err)
+	}
+	var ops []string
+	for path, item := range doc.Paths {
+		for op := range item {
+			ops = append(ops, op+" "+path)
+		}
+	}
+	if len(ops) != 9 {
+		t.Errorf("unexpected operations %q", ops)
+	}
+	for _, s := range []string{
+		`"operationId": "users_post"`,
+		`"operationId": "user"`,
+		`"description": "Returns a user."`,
+		`"deprecated": true`,
+		`"requestBody"`,
+		`"type": "integer"`,
+	} {
+		if !strings.Contains(string(b), s) {
+			t.Errorf("expected %s in:\n%s", s, b)
+		}
+	}
+
+	y, err := d.YAML()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var fromJSON, fromYAML interface{}
+	json.Unmarshal(b, &fromJSON)
+	if err := yaml.Unmarshal(y, &fromYAML); err != nil {
+		t.Fatal(err)
+	}
+	j1, _ := json.Marshal(fromJSON)
+	j2, _ := json.Marshal(fromYAML)
+	if string(j1) != string(j2) {
+		t.Errorf("YAML and JSON documents differ:\n%s\n%s", j1, j2)
+	}
+}
+
+func TestOperationIDs(t *testing.T) {
+	h := http.NotFoundHandler()
+	r := mpath.New()
+	r.Route("/any").Name("any").Handle(h)
+	r.Route("/archive/:year?/:month?").Name("archive").Get(h)
+
+	d := Generate(r, Info{Title: "Test", Version: "1.0"})
+	var ids []string
+	for path, item := range d["paths"].(map[string]interface{}) {
+		for m, op := range item.(map[string]interface{}) {
+			if op, ok := op.(map[string]interface{}); ok {
+				ids = append(ids, m+" "+path+" "+op["operationId"].(string))
+			}
+		}
+	}
+	sort.Strings(ids)
+	expected := []string{
+		"delete /any any_delete",
+		"get /any any_get",
+		"patch /any any_patch",
+		"post /any any_post",
+		"put /any any_put",
+		"get /archive archive",
+		"get /archive/{year} archive_year",
+		"get /archive/{year}/{month} archive_year_month",
+	}
+	sort.Strings(expected)
+	if strings.Join(ids, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("unexpected operation IDs:\n%s", strings.Join(ids, "\n"))
+	}
+}
+
+func TestGenerateRegexp(t *testing.T) {
+	r := mregex.New()
+	r.Route("/articles/{category}/{id:[0-9]+}").Get(http.NotFoundHandler())
+	d := Generate(r, Info{Title: "Test", Version: "1.0"})
+	paths := d["paths"].(map[string]interface{})
+	if _, ok := paths["/articles/{category}/{id}"]; !ok {
+		t.Errorf("unexpected paths %v", paths)
+	}
+}
@@ -1,7 +1,11 @@
 package muxy
 
 import (
+	"errors"
 	"net/http"
+	"net/url"
+	"reflect"
+	"strings"
 )
 
 // Matcher registers patterns as routes and matches requests.
@@ -9,24 +13,57 @@ type Matcher interface {
 	// Route returns a Route for the given pattern.
 	Route(pattern string) (*Route, error)
 	// Match matches registered routes against the incoming request and
-	// stores URL variables in the request context.
+	// stores URL variables in the request context using WithVars.
+	//
+	// The returned handler is normally the matched *Route, which dispatches
+	// by request method. Matchers can also return their own handlers, for
+	// example to reject malformed requests, or nil if no route matches.
 	Match(r *http.Request) (http.Handler, *http.Request)
 	// Build returns a URL string for the given route and variables.
 	Build(r *Route, vars ...string) (string, error)
 }
 
-// -----------------------------------------------------------------------------
+// ConfigMatcher is implemented by matchers that accept options changing how
+// requests are matched.
+type ConfigMatcher interface {
+	Matcher
+	// Config returns a comparable value describing the matcher options.
+	Config() interface{}
+}
 
-// Variable is a type used to set and retrieve route variables from the request
-// context.
-type Variable string
+// PathMatcher is implemented by matchers that can describe the paths their
+// routes match, for tools such as documentation generators.
+type PathMatcher interface {
+	Matcher
+	// Paths returns the path templates matched by a route. Patterns with
+	// optional parts result in one template for each form, shortest first.
+	Paths(r *Route) [][]PathPart
+}
 
-// Var returns the route variable with the given name from the request context.
-//
-// The returned value may be empty if the variable wasn't set.
-func Var(r *http.Request, name string) string {
-	v, _ := r.Context().Value(Variable(name)).(string)
-	return v
+// PathPart is a part of a path template: literal text or a variable.
+type PathPart struct {
+	// Literal holds the text of a literal part.
+	Literal string
+	// Name holds the name of a variable; it is empty for literal parts.
+	Name string
+	// Type holds the value type of a variable, "int" or "uuid", if known.
+	Type string
+	// Pattern holds a regexp that variable values must match, if any.
+	Pattern string
+	// Wildcard reports whether the variable matches the rest of the path.
+	Wildcard bool
+}
+
+// sameMatcher reports whether two matchers have the same type and, if they
+// implement ConfigMatcher, the same options.
+func sameMatcher(a, b Matcher) bool {
+	if reflect.TypeOf(a) != reflect.TypeOf(b) {
+		return false
+	}
+	if ca, ok := a.(ConfigMatcher); ok {
+		return ca.Config() == b.(ConfigMatcher).Config()
+	}
+	return true
 }
 
 // -----------------------------------------------------------------------------
@@ -53,20 +90,94 @@ type Router struct {
 	Pattern string
 	// Noun holds the name prefix used to create new routes.
 	Noun string
-	// Middleware holds the middleware to apply in new routes.
+	// Middleware holds the middleware added to this router by Use. Routes
+	// are also wrapped by the middleware of the routers it was grouped from.
 	Middleware []func(http.Handler) http.Handler
 	// Routes maps all routes to their correspondent patterns.
 	Routes map[*Route]string
 	// NamedRoutes maps route names to their correspondent routes.
 	NamedRoutes map[string]*Route
+	// routes holds all routes in registration order.
+	routes []*Route
+	// parent holds the router that created this group, if any.
+	parent *Router
+	// notFound holds the NotFound handler, if any.
+	notFound http.Handler
+	// methodNotAllowed holds the MethodNotAllowed handler, if any.
+	methodNotAllowed http.Handler
+	// notFoundRouters holds the routers with a NotFound handler.
+	notFoundRouters []*Router
+	// cors holds the CORS options, if any.
+	cors *CORSOptions
+	// wrappers holds the middleware added by Wrap.
+	wrappers []func(http.Handler) http.Handler
+	// handler holds the router wrapped by its wrappers, if any.
+	handler http.Handler
+	// mounts holds the routers mounted with a different matcher.
+	mounts []*mount
+	// meta holds the metadata for routes registered in this router.
+	meta meta
 }
 
 // Use appends the given middleware to this router.
+//
+// The middleware applies to the routes registered in this router and in its
+// groups, including routes registered before calling Use. It only runs when
+// a route matches; see Wrap for middleware that runs for every request.
 func (r *Router) Use(middleware ...func(http.Handler) http.Handler) *Router {
 	r.Middleware = append(r.Middleware, middleware...)
+	for _, route := range r.Router.routes {
+		if route.Router.descends(r) {
+			route.compile()
+		}
+	}
+	for _, m := range r.Router.mounts {
+		if m.router.descends(r) {
+			m.compile()
+		}
+	}
+	return r
+}
+
+// Wrap appends the given middleware to the main router. Unlike Use, the
+// middleware wraps the whole router, so it also runs for requests that
+// don't match any route.
+func (r *Router) Wrap(middleware ...func(http.Handler) http.Handler) *Router {
+	m := r.Router
+	m.wrappers = append(m.wrappers, middleware...)
+	m.handler = chain(m.wrappers, http.HandlerFunc(m.serve))
 	return r
 }
 
+// descends reports whether the router is g or one of its groups.
+func (r *Router) descends(g *Router) bool {
+	for ; r != nil; r = r.parent {
+		if r == g {
+			return true
+		}
+	}
+	return false
+}
+
+// middleware returns the middleware for routes registered in this router,
+// starting with the one from the main router.
+func (r *Router) middleware() []func(http.Handler) http.Handler {
+	var mw []func(http.Handler) http.Handler
+	for ; r != nil; r = r.parent {
+		mw = append(append([]func(http.Handler) http.Handler{}, r.Middleware...), mw...)
+	}
+	return mw
+}
+
+// chain wraps a handler with the given middleware; the first one is the
+// outermost.
+func chain(middleware []func(http.Handler) http.Handler, h http.Handler) http.Handler {
+	for i := len(middleware) - 1; i >= 0; i-- {
+		h = middleware[i](h)
+	}
+	return h
+}
+
 // Group creates a group for the given pattern prefix. All routes registered in
 // the resulting router will prepend the prefix to its pattern. For example:
 //
@@ -79,10 +190,10 @@ func (r *Router) Use(middleware ...func(http.Handler) http.Handler) *Router {
 //     g.Route("/products").Get(listProducts).Post(updateProducts)
 func (r *Router) Group(pattern string) *Router {
 	return &Router{
-		Router:     r.Router,
-		Pattern:    r.Pattern + pattern,
-		Noun:       r.Noun,
-		Middleware: r.Middleware,
+		Router:  r.Router,
+		parent:  r,
+		Pattern: r.Pattern + pattern,
+		Noun:    r.Noun,
 	}
 }
 
@@ -105,48 +216,260 @@ func (r *Router) Name(name string) *Router {
 //     // set the name prefix as "admin:" and register all routes from the
 //     // external router.
 //     g := r.Group("/admin").Name("admin:").Mount(admin.Router)
+//
+// Routes keep their handlers, names, conditions, CORS options and metadata,
+// which then also inherit from this router. They are
+// wrapped by the middleware of this router followed by the middleware the
+// source router had when mounted.
+//
+// If the source router uses a different kind of Matcher, or one with
+// different options as reported by ConfigMatcher, its routes can't be
+// imported. Instead, requests whose path starts with the group pattern,
+// which must be static, and that don't match any route of this router,
+// are passed to the source router with the prefix removed, wrapped by the
+// middleware of this router. Its named routes can still be built using the
+// name prefix, but its routes are not reported by Walk. Mount panics if
+// the pattern is not static.
 func (r *Router) Mount(src *Router) *Router {
-	for k, _ := range src.Router.Routes {
-		route := r.Route(k.Pattern).Name(k.Noun)
-		for method, handler := range k.Handlers {
-			route.Handle(handler, method)
+	if !sameMatcher(src.Router.matcher, r.Router.matcher) {
+		if staticPrefix(r.Pattern) != r.Pattern {
+			panic(&RouteError{Pattern: r.Pattern, Err: errors.New("muxy: cannot mount a router with a different matcher on a pattern with variables")})
+		}
+		m := &mount{router: r, src: src, prefix: strings.TrimSuffix(r.Pattern, "/"), noun: r.Noun}
+		m.compile()
+		r.Router.mounts = append(r.Router.mounts, m)
+		return r
+	}
+	for _, k := range src.Router.routes {
+		if !k.Router.descends(src) {
+			continue
+		}
+		route := r.Route(k.Pattern)
+		if src.Router.NamedRoutes[k.Noun] == k {
+			route.Name(k.Noun)
+		}
+		for method, h := range k.Handlers {
+			route.Handle(h, method)
 		}
+		route.host, route.schemes, route.queries = k.host, k.schemes, k.queries
+		route.cors = k.corsOptions()
+		md := k.Metadata()
+		route.meta = meta{md.Description, md.Tags, md.Deprecated, md.Values}
+		route.mounted = k.Middleware()
+		route.compile()
 	}
 	return r
 }
 
+// mount is a router mounted with a different matcher.
+type mount struct {
+	// router holds the group the router was mounted in.
+	router *Router
+	// src holds the mounted router.
+	src *Router
+	// prefix holds the path prefix, without a trailing slash.
+	prefix string
+	// noun holds the name prefix.
+	noun string
+	// handler holds the mounted router wrapped by the group middleware.
+	handler http.Handler
+}
+
+// compile wraps the mounted router with the middleware of its group.
+func (m *mount) compile() {
+	m.handler = chain(m.router.middleware(), http.HandlerFunc(m.serve))
+}
+
+// serve passes the request to the mounted router, removing the prefix
+// from the URL path. The prefix is restored in relative Location headers,
+// so that redirects issued by the mounted router stay under the prefix.
+func (m *mount) serve(w http.ResponseWriter, req *http.Request) {
+	r2 := new(http.Request)
+	*r2 = *req
+	r2.URL = new(url.URL)
+	*r2.URL = *req.URL
+	r2.URL.Path = "/" + strings.TrimPrefix(strings.TrimPrefix(req.URL.Path, m.prefix), "/")
+	if rp := req.URL.RawPath; rp != "" {
+		if strings.HasPrefix(rp, m.prefix) {
+			r2.URL.RawPath = "/" + strings.TrimPrefix(strings.TrimPrefix(rp, m.prefix), "/")
+		} else {
+			r2.URL.RawPath = ""
+		}
+	}
+	m.src.ServeHTTP(&prefixWriter{ResponseWriter: w, prefix: m.prefix}, r2)
+}
+
+// prefixWriter adds a path prefix to the Location header of responses
+// when it holds an absolute path.
+type prefixWriter struct {
+	http.ResponseWriter
+	prefix      string
+	wroteHeader bool
+}
+
+func (w *prefixWriter) WriteHeader(code int) {
+	if !w.wroteHeader {
+		w.wroteHeader = true
+		h := w.Header()
+		if loc := h.Get("Location"); strings.HasPrefix(loc, "/") && !strings.HasPrefix(loc, "//") {
+			h.Set("Location", w.prefix+loc)
+		}
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *prefixWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// Flush implements http.Flusher if the underlying writer does.
+func (w *prefixWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		if !w.wroteHeader {
+			w.WriteHeader(http.StatusOK)
+		}
+		f.Flush()
+	}
+}
+
+// Unwrap returns the underlying writer, for http.ResponseController.
+func (w *prefixWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// build returns a URL for a named route of the mounted router.
+func (m *mount) build(name string, vars ...string) (string, error) {
+	if !strings.HasPrefix(name, m.noun) {
+		return "", &RouteError{Name: name, Err: ErrUnknownRoute}
+	}
+	u, err := m.src.BuildURL(name[len(m.noun):], vars...)
+	if err != nil {
+		return "", err
+	}
+	if strings.HasPrefix(u, "/") {
+		u = m.prefix + u
+	}
+	return u, nil
+}
+
+// mountFor returns the mounted router with the longest prefix matching the
+// request path, or nil if there's none.
+func (r *Router) mountFor(req *http.Request) *mount {
+	var mt *mount
+	for _, m := range r.mounts {
+		if hasPathPrefix(req.URL.Path, m.prefix) && (mt == nil || len(m.prefix) > len(mt.prefix)) {
+			mt = m
+		}
+	}
+	return mt
+}
+
 // Route creates a new Route for the given pattern.
+//
+// It panics if the matcher rejects the pattern; use TryRoute for patterns
+// that are not known at compile time.
 func (r *Router) Route(pattern string) *Route {
-	route, err := r.Router.matcher.Route(r.Pattern + pattern)
+	route, err := r.TryRoute(pattern)
 	if err != nil {
 		panic(err)
 	}
+	return route
+}
+
+// TryRoute creates a new Route for the given pattern, or returns a
+// *RouteError if the matcher rejects the pattern.
+func (r *Router) TryRoute(pattern string) (*Route, error) {
+	route, err := r.Router.matcher.Route(r.Pattern + pattern)
+	if err != nil {
+		return nil, &RouteError{Pattern: r.Pattern + pattern, Err: err}
+	}
 	route.Router = r
 	route.Pattern = r.Pattern + pattern
 	route.Noun = r.Noun
 	r.Router.Routes[route] = r.Pattern + pattern
-	return route
+	r.Router.routes = append(r.Router.routes, route)
+	route.compile()
+	return route, nil
 }
 
-// URL returns a URL string for the given route name and variables.
+// URL returns a URL string for the given route name and variables, passed
+// as key/value pairs.
+//
+// It returns an empty string if there's no route with the given name, and
+// panics if the URL can't be built; use BuildURL to handle these cases.
 func (r *Router) URL(name string, vars ...string) string {
-	if route, ok := r.Router.NamedRoutes[name]; ok {
-		u, err := r.Router.matcher.Build(route, vars...)
-		if err != nil {
-			panic(err)
+	u, err := r.BuildURL(name, vars...)
+	if errors.Is(err, ErrUnknownRoute) {
+		return ""
+	}
+	if err != nil {
+		panic(err)
+	}
+	return u
+}
+
+// BuildURL returns a URL string for the given route name and variables,
+// passed as key/value pairs.
+//
+// The error wraps ErrUnknownRoute if there's no route with the given name,
+// and ErrMissingVar if a variable is missing, in which case a *VarError
+// holds the variable name.
+func (r *Router) BuildURL(name string, vars ...string) (string, error) {
+	route, ok := r.Router.NamedRoutes[name]
+	if !ok {
+		for _, m := range r.Router.mounts {
+			if u, err := m.build(name, vars...); !errors.Is(err, ErrUnknownRoute) {
+				return u, err
+			}
 		}
-		return u
+		return "", &RouteError{Name: name, Err: ErrUnknownRoute}
 	}
-	return ""
+	u, err := route.build(vars...)
+	if err != nil {
+		return "", &RouteError{Name: name, Pattern: route.Pattern, Err: err}
+	}
+	return u, nil
+}
+
+// BuildHostURL is like BuildURL, but returns an absolute URL using the
+// given host if the route doesn't have a host template. The scheme is the
+// first one set with Route.Schemes, or "http".
+func (r *Router) BuildHostURL(host, name string, vars ...string) (string, error) {
+	u, err := r.BuildURL(name, vars...)
+	if err != nil || !strings.HasPrefix(u, "/") {
+		return u, err
+	}
+	scheme := "http"
+	if route, ok := r.Router.NamedRoutes[name]; ok && route.schemes != nil {
+		scheme = route.schemes[0]
+	}
+	return scheme + "://" + host + u, nil
 }
 
 // ServeHTTP dispatches to the handler whose pattern matches the request.
 func (r *Router) ServeHTTP(w http.ResponseWriter, req *http.Request) {
-	if h, hreq := r.Router.matcher.Match(req); h != nil {
+	if h := r.Router.handler; h != nil {
+		h.ServeHTTP(w, req)
+		return
+	}
+	r.Router.serve(w, req)
+}
+
+// serve dispatches to the handler whose pattern matches the request,
+// without the middleware set by Wrap.
+func (r *Router) serve(w http.ResponseWriter, req *http.Request) {
+	if h, hreq := r.matcher.Match(req); h != nil {
 		h.ServeHTTP(w, hreq)
 		return
 	}
-	http.NotFound(w, req)
+	if m := r.mountFor(req); m != nil {
+		m.handler.ServeHTTP(w, req)
+		return
+	}
+	r.notFoundHandler(req).ServeHTTP(w, req)
 }
 
 // -----------------------------------------------------------------------------
@@ -160,25 +483,50 @@ type Route struct {
 	Pattern string
 	// Noun holds the route name.
 	Noun string
-	// Handlers maps request methods to the handlers that will handle them.
+	// Handlers maps request methods to the handlers that will handle them,
+	// as registered; middleware is applied when serving the route.
 	Handlers map[string]http.Handler
+	// host holds the host template, if any.
+	host *Template
+	// schemes holds the URL schemes to match, if any.
+	schemes []string
+	// queries holds the query conditions, if any.
+	queries []query
+	// cors holds the CORS options overriding the router ones, if any.
+	cors *CORSOptions
+	// meta holds the route metadata, not including the inherited one.
+	meta meta
+	// mounted holds the middleware carried over by Mount, if any.
+	mounted []func(http.Handler) http.Handler
+	// handler holds the route dispatcher wrapped by the router middleware.
+	handler http.Handler
 }
 
 // Name defines the route name used for URL building.
+//
+// It panics if the name is already in use; use TryName for names that are
+// not known at compile time.
 func (r *Route) Name(name string) *Route {
-	r.Noun = r.Noun + name
-	if _, ok := r.Router.Router.NamedRoutes[r.Noun]; ok {
-		panic("muxy: duplicated name: " + r.Noun)
+	if err := r.TryName(name); err != nil {
+		panic(err)
 	}
-	r.Router.Router.NamedRoutes[r.Noun] = r
 	return r
 }
 
+// TryName defines the route name used for URL building, or returns a
+// *RouteError wrapping ErrDuplicateName if the name is already in use.
+func (r *Route) TryName(name string) error {
+	noun := r.Noun + name
+	if _, ok := r.Router.Router.NamedRoutes[noun]; ok {
+		return &RouteError{Name: noun, Pattern: r.Pattern, Err: ErrDuplicateName}
+	}
+	r.Noun = noun
+	r.Router.Router.NamedRoutes[noun] = r
+	return nil
+}
+
 // Handle sets the given handler to be served for the optional request methods.
 func (r *Route) Handle(h http.Handler, methods ...string) *Route {
-	for i := len(r.Router.Middleware) - 1; i >= 0; i-- {
-		h = r.Router.Middleware[i](h)
-	}
 	if r.Handlers == nil {
 		r.Handlers = make(map[string]http.Handler, len(methods))
 	}
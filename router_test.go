@@ -1 +1,766 @@
 package muxy_test
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/muxy"
+	"github.com/gorilla/muxy/matchers/mpath"
+	"github.com/gorilla/muxy/matchers/mregex"
+)
+
+// varsHandler returns a handler that writes the given route variables,
+// separated by spaces.
+func varsHandler(names ...string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for i, name := range names {
+			if i > 0 {
+				w.Write([]byte(" "))
+			}
+			w.Write([]byte(muxy.Var(r, name)))
+		}
+	})
+}
+
+func serve(h http.Handler, method, url string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(method, url, nil))
+	return w
+}
+
+func TestConditions(t *testing.T) {
+	r := mpath.New()
+	r.Route("/:page").Host("{sub}.example.com").Handle(varsHandler("sub", "page"))
+	r.Route("/:page").Host("www.example.org").Schemes("https").Handle(varsHandler("page"))
+	r.Route("/:page").Host("{sub:[a-z]+}.example.net").Handle(varsHandler("sub", "page"))
+	r.Route("/:page").Host("{code:[a-z]{3}}.example.io").Handle(varsHandler("code", "page"))
+	r.Route("/:page").Queries("format", "{format:json|xml}", "debug", "").Handle(varsHandler("format", "page"))
+	r.Route("/:page").Queries("id", "{id:[0-9]{2}}").Handle(varsHandler("id", "page"))
+	r.Route("/:page").Handle(varsHandler("page"))
+
+	tests := []struct {
+		url  string
+		body string
+	}{
+		{"http://foo.example.com/a", "foo a"},
+		{"http://FOO.Example.com:8080/a", "FOO a"},
+		{"http://foo.bar.example.com/a?format=json&debug", "json a"},
+		{"http://foo.bar.example.com/a", "a"},
+		{"https://www.example.org/b", "b"},
+		{"http://www.example.org/b?format=xml&debug=1", "xml b"},
+		{"http://www.example.org/b?format=yaml&debug=1", "b"},
+		{"http://www.example.org/b?format=json", "b"},
+		{"http://foo.example.net:8080/a", "foo a"},
+		{"http://abc.example.io:8080/a", "abc a"},
+		{"http://abcd.example.io/a", "a"},
+		{"http://www.example.org/b?id=42", "42 b"},
+		{"http://www.example.org/b?id=420", "b"},
+	}
+	for _, v := range tests {
+		if w := serve(r, "GET", v.url); w.Body.String() != v.body {
+			t.Errorf("%q: expected body %q; got %q", v.url, v.body, w.Body.String())
+		}
+	}
+}
+
+func TestParseTemplate(t *testing.T) {
+	tpl, err := muxy.ParseTemplate("/{a}-{b:[0-9]{2}}.txt", "[^/]+")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(tpl.Parts, tpl.Names, tpl.Patterns) != "[/ - .txt] [a b] [[^/]+ [0-9]{2}]" {
+		t.Errorf("unexpected template %+v", tpl)
+	}
+	if m := tpl.Regexp.FindStringSubmatch("/x-42.txt"); fmt.Sprint(m) != "[/x-42.txt x 42]" {
+		t.Errorf("unexpected match %q", m)
+	}
+	for _, v := range []string{"{a", "a}", "{a}{a}", "{a:(b)}", "{:b}", "{a:[}"} {
+		if _, err := muxy.ParseTemplate(v, ".*"); err == nil {
+			t.Errorf("%q: expected error", v)
+		}
+	}
+}
+
+func TestConditionsURL(t *testing.T) {
+	r := mpath.New()
+	r.Route("/:page").Host("{sub}.example.com").Name("host")
+	r.Route("/:page").Host("www.example.org").Schemes("https").Name("scheme")
+	r.Route("/search").Queries("q", "{query}", "format", "json").Name("query")
+
+	tests := []struct {
+		name string
+		vars []string
+		url  string
+	}{
+		{"host", []string{"sub", "foo", "page", "a"}, "http://foo.example.com/a"},
+		{"scheme", []string{"page", "a b"}, "https://www.example.org/a%20b"},
+		{"query", []string{"query", "a&b"}, "/search?q=a%26b&format=json"},
+	}
+	for _, v := range tests {
+		if u := r.URL(v.name, v.vars...); u != v.url {
+			t.Errorf("%q %v: expected %q; got %q", v.name, v.vars, v.url, u)
+		}
+	}
+}
+
+func TestConditionsErrors(t *testing.T) {
+	route := mpath.New().Route("/a")
+	var rerr *muxy.RouteError
+	for _, err := range []error{
+		route.TryHost("{sub.example.com"),
+		route.TryHost("{sub:(a|b)}.example.com"),
+		route.TryQueries("q"),
+		route.TryQueries("q", "{query}", "x", "{x:[}"),
+	} {
+		if !errors.As(err, &rerr) || rerr.Pattern != "/a" {
+			t.Errorf("expected *RouteError for pattern %q; got %v", "/a", err)
+		}
+	}
+	if route.HasConditions() {
+		t.Errorf("expected no conditions after errors")
+	}
+}
+
+func TestConditionsDuplicate(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Errorf("expected panic registering a route after an unconditional one")
+		}
+	}()
+	r := mpath.New()
+	r.Route("/").Host("example.com")
+	r.Route("/")
+	r.Route("/").Host("example.org")
+}
+
+func TestErrors(t *testing.T) {
+	r := mpath.New()
+	r.Route("/users/:id").Name("user")
+
+	if _, err := r.TryRoute("/users/:name"); err == nil {
+		t.Errorf("expected error registering a duplicated pattern")
+	}
+	if _, err := r.TryRoute("/:1"); err == nil {
+		t.Errorf("expected error registering an invalid pattern")
+	}
+
+	err := r.Route("/people/:id").TryName("user")
+	if !errors.Is(err, muxy.ErrDuplicateName) {
+		t.Errorf("expected ErrDuplicateName; got %v", err)
+	}
+	var rerr *muxy.RouteError
+	if !errors.As(err, &rerr) || rerr.Name != "user" || rerr.Pattern != "/people/:id" {
+		t.Errorf("expected RouteError for %q; got %#v", "user", rerr)
+	}
+
+	if _, err := r.BuildURL("person", "id", "1"); !errors.Is(err, muxy.ErrUnknownRoute) {
+		t.Errorf("expected ErrUnknownRoute; got %v", err)
+	}
+	if u := r.URL("person", "id", "1"); u != "" {
+		t.Errorf("expected empty URL for unknown route; got %q", u)
+	}
+
+	_, err = r.BuildURL("user")
+	if !errors.Is(err, muxy.ErrMissingVar) {
+		t.Errorf("expected ErrMissingVar; got %v", err)
+	}
+	var verr *muxy.VarError
+	if !errors.As(err, &verr) || verr.Name != "id" {
+		t.Errorf("expected VarError for %q; got %#v", "id", verr)
+	}
+
+	if u, err := r.BuildURL("user", "id", "1"); err != nil || u != "/users/1" {
+		t.Errorf("expected %q; got %q, %v", "/users/1", u, err)
+	}
+}
+
+func TestWalk(t *testing.T) {
+	mw := func(h http.Handler) http.Handler { return h }
+	h := http.NotFoundHandler()
+	r := mpath.New()
+	r.Route("/b").Name("b").Get(h).Post(h)
+	g := r.Group("/admin").Name("admin:").Use(mw)
+	g.Route("/a").Name("a").Handle(h)
+	r.Route("/a").Delete(h)
+
+	var got []string
+	err := r.Walk(func(route *muxy.Route, methods []string) error {
+		got = append(got, fmt.Sprintf("%s %s %v %d", route.Pattern, route.Noun, methods, len(route.Middleware())))
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"/b b [GET POST] 0", "/admin/a admin:a [*] 1", "/a  [DELETE] 0"}
+	if strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Errorf("expected %q; got %q", want, got)
+	}
+
+	stop := errors.New("stop")
+	n := 0
+	if err := r.Walk(func(*muxy.Route, []string) error { n++; return stop }); err != stop || n != 1 {
+		t.Errorf("expected walk to stop after the first error; got %v after %d routes", err, n)
+	}
+
+	w := serve(muxy.RoutesHandler(r), "GET", "/routes")
+	if body := w.Body.String(); !strings.Contains(body, "GET,POST  /b ") || !strings.Contains(body, "/admin/a  admin:a  1") {
+		t.Errorf("unexpected text output:\n%s", body)
+	}
+	w = serve(muxy.RoutesHandler(r), "GET", "/routes?format=json")
+	if body := w.Body.String(); !strings.HasPrefix(body, `[{"pattern":"/b","name":"b","methods":["GET","POST"],"middleware":0}`) {
+		t.Errorf("unexpected JSON output:\n%s", body)
+	}
+}
+
+func TestNotFoundAndMethodNotAllowed(t *testing.T) {
+	text := func(s string) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(s + " " + strings.Join(muxy.AllowedMethods(r), ",")))
+		})
+	}
+	h := text("ok")
+	r := mpath.New()
+	r.Route("/a").Get(h)
+	api := r.Group("/api").NotFound(text("api 404")).MethodNotAllowed(text("api 405"))
+	api.Route("/a").Post(h)
+	users := api.Group("/users/:id")
+	users.Route("/posts").Put(h)
+	r.Group("/api/users").NotFound(text("users 404"))
+
+	tests := []struct {
+		method string
+		url    string
+		code   int
+		body   string
+	}{
+		{"GET", "/a", 200, "ok "},
+		{"HEAD", "/a", 200, "ok "},
+		{"POST", "/a", 405, "405 Method Not Allowed\n"},
+		{"OPTIONS", "/a", 200, "200 OK\n"},
+		{"GET", "/b", 404, "404 page not found\n"},
+		{"GET", "/api/a", 200, "api 405 OPTIONS,POST"},
+		{"GET", "/api/users/1/posts", 200, "api 405 OPTIONS,PUT"},
+		{"GET", "/api/b", 200, "api 404 "},
+		{"GET", "/apix", 404, "404 page not found\n"},
+		{"GET", "/api/users/1/comments", 200, "users 404 "},
+	}
+	for _, v := range tests {
+		w := serve(r, v.method, v.url)
+		if w.Code != v.code || w.Body.String() != v.body {
+			t.Errorf("%s %s: expected %d %q; got %d %q", v.method, v.url, v.code, v.body, w.Code, w.Body.String())
+		}
+	}
+	if w := serve(r, "POST", "/a"); w.Header().Get("Allow") != "GET, HEAD, OPTIONS" {
+		t.Errorf("expected Allow header %q; got %q", "GET, HEAD, OPTIONS", w.Header().Get("Allow"))
+	}
+}
+
+func TestCORS(t *testing.T) {
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	r := mpath.New().CORS(&muxy.CORSOptions{
+		AllowedOrigins: []string{"https://example.com", "https://*.example.org"},
+		AllowedHeaders: []string{"X-Token"},
+		ExposedHeaders: []string{"X-Total"},
+		MaxAge:         time.Hour,
+	})
+	r.Route("/a").Get(h).Post(h)
+	r.Route("/b").Get(h).CORS(&muxy.CORSOptions{
+		AllowedOrigins:   []string{"*"},
+		AllowCredentials: true,
+	})
+	r.Group("/c").CORS(&muxy.CORSOptions{AllowedOrigins: []string{"*"}}).Route("/").Handle(h)
+	r.Group("/d").Use(func(http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+		})
+	}).Route("/").Get(h)
+
+	tests := []struct {
+		method  string
+		url     string
+		headers map[string]string
+		code    int
+		want    map[string]string
+	}{
+		// preflight
+		{"OPTIONS", "/a", map[string]string{"Origin": "https://example.com", "Access-Control-Request-Method": "POST", "Access-Control-Request-Headers": "x-token, content-type"}, 204, map[string]string{
+			"Access-Control-Allow-Origin":  "https://example.com",
+			"Access-Control-Allow-Methods": "GET, HEAD, OPTIONS, POST",
+			"Access-Control-Allow-Headers": "x-token, content-type",
+			"Access-Control-Max-Age":       "3600",
+		}},
+		{"OPTIONS", "/a", map[string]string{"Origin": "https://api.example.org", "Access-Control-Request-Method": "GET"}, 204, map[string]string{
+			"Access-Control-Allow-Origin": "https://api.example.org",
+		}},
+		{"OPTIONS", "/a", map[string]string{"Origin": "https://example.com", "Access-Control-Request-Method": "DELETE"}, 200, map[string]string{
+			"Access-Control-Allow-Origin": "",
+			"Allow":                       "GET, HEAD, OPTIONS, POST",
+		}},
+		{"OPTIONS", "/a", map[string]string{"Origin": "https://example.com", "Access-Control-Request-Method": "GET", "Access-Control-Request-Headers": "X-Other"}, 200, map[string]string{
+			"Access-Control-Allow-Origin": "",
+		}},
+		{"OPTIONS", "/a", map[string]string{"Origin": "https://example.net", "Access-Control-Request-Method": "GET"}, 200, map[string]string{
+			"Access-Control-Allow-Origin": "",
+		}},
+		{"OPTIONS", "/c/", map[string]string{"Origin": "https://example.net", "Access-Control-Request-Method": "PATCH"}, 204, map[string]string{
+			"Access-Control-Allow-Origin":  "*",
+			"Access-Control-Allow-Methods": "PATCH",
+		}},
+		{"OPTIONS", "/d/", map[string]string{"Origin": "https://example.com", "Access-Control-Request-Method": "GET"}, 204, map[string]string{
+			"Access-Control-Allow-Origin": "https://example.com",
+		}},
+		// actual requests
+		{"GET", "/a", map[string]string{"Origin": "https://example.com"}, 200, map[string]string{
+			"Access-Control-Allow-Origin":   "https://example.com",
+			"Access-Control-Expose-Headers": "X-Total",
+			"Vary":                          "Origin",
+		}},
+		{"GET", "/a", nil, 200, map[string]string{
+			"Access-Control-Allow-Origin": "",
+		}},
+		{"GET", "/b", map[string]string{"Origin": "https://example.net"}, 200, map[string]string{
+			"Access-Control-Allow-Origin":      "https://example.net",
+			"Access-Control-Allow-Credentials": "true",
+		}},
+		{"GET", "/d/", map[string]string{"Origin": "https://example.com"}, 401, map[string]string{
+			"Access-Control-Allow-Origin": "https://example.com",
+		}},
+	}
+	for _, v := range tests {
+		req := httptest.NewRequest(v.method, v.url, nil)
+		for k, value := range v.headers {
+			req.Header.Set(k, value)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		if w.Code != v.code {
+			t.Errorf("%s %s %v: expected status %d; got %d", v.method, v.url, v.headers, v.code, w.Code)
+		}
+		for k, value := range v.want {
+			if got := w.Header().Get(k); got != value {
+				t.Errorf("%s %s %v: expected %s %q; got %q", v.method, v.url, v.headers, k, value, got)
+			}
+		}
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	tag := func(s string) func(http.Handler) http.Handler {
+		return func(h http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte(s + " "))
+				h.ServeHTTP(w, r)
+			})
+		}
+	}
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("h"))
+	})
+	r := mpath.New().Use(tag("a"))
+	r.Route("/a").Get(h)
+	g := r.Group("/g").Use(tag("g1"))
+	g.Route("/b").Get(h)
+	// Middleware added after routes are registered still applies.
+	r.Use(tag("b"))
+	g.Use(tag("g2"))
+	r.Wrap(tag("w"))
+
+	tests := []struct {
+		method string
+		url    string
+		body   string
+	}{
+		{"GET", "/a", "w a b h"},
+		{"GET", "/g/b", "w a b g1 g2 h"},
+		{"POST", "/g/b", "w a b g1 g2 405 Method Not Allowed\n"},
+		{"GET", "/c", "w 404 page not found\n"},
+	}
+	for _, v := range tests {
+		if w := serve(r, v.method, v.url); w.Body.String() != v.body {
+			t.Errorf("%s %s: expected body %q; got %q", v.method, v.url, v.body, w.Body.String())
+		}
+	}
+}
+
+func TestVars(t *testing.T) {
+	var req *http.Request
+	r := mpath.New()
+	r.Route("/:id/:uuid/:date/:empty?").Host("{sub}.example.com").Handle(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		req = r
+	}))
+	serve(r, "GET", "http://www.example.com/42/f81d4fae-7DEC-11d0-a765-00a0c91e6bf6/2016-05-01")
+	if req == nil {
+		t.Fatal("expected match")
+	}
+	expected := map[string]string{"id": "42", "uuid": "f81d4fae-7DEC-11d0-a765-00a0c91e6bf6", "date": "2016-05-01", "sub": "www"}
+	if vars := muxy.Vars(req); fmt.Sprint(vars) != fmt.Sprint(expected) {
+		t.Errorf("expected %v; got %v", expected, vars)
+	}
+	if v, ok := muxy.LookupVar(req, "id"); v != "42" || !ok {
+		t.Errorf("expected %q, true; got %q, %v", "42", v, ok)
+	}
+	if _, ok := muxy.LookupVar(req, "empty"); ok {
+		t.Errorf("expected missing variable")
+	}
+	if v := muxy.Vars(httptest.NewRequest("GET", "/", nil)); v != nil {
+		t.Errorf("expected no variables; got %v", v)
+	}
+	// Later values take precedence.
+	if v := muxy.Var(muxy.WithVars(req, "id", "7"), "id"); v != "7" {
+		t.Errorf("expected %q; got %q", "7", v)
+	}
+
+	if i, err := muxy.VarInt(req, "id"); i != 42 || err != nil {
+		t.Errorf("expected 42, nil; got %d, %v", i, err)
+	}
+	if i, err := muxy.VarInt64(req, "id"); i != 42 || err != nil {
+		t.Errorf("expected 42, nil; got %d, %v", i, err)
+	}
+	if u, err := muxy.VarUUID(req, "uuid"); u.String() != "f81d4fae-7dec-11d0-a765-00a0c91e6bf6" || err != nil {
+		t.Errorf("unexpected UUID %v, %v", u, err)
+	}
+	if d, err := muxy.VarTime(req, "date", "2006-01-02"); !d.Equal(time.Date(2016, 5, 1, 0, 0, 0, 0, time.UTC)) || err != nil {
+		t.Errorf("unexpected time %v, %v", d, err)
+	}
+	var verr *muxy.VarError
+	if _, err := muxy.VarInt(req, "date"); !errors.As(err, &verr) || verr.Name != "date" {
+		t.Errorf("expected *VarError for %q; got %v", "date", err)
+	}
+	if _, err := muxy.VarUUID(req, "id"); err == nil {
+		t.Errorf("expected error parsing UUID")
+	}
+	if _, err := muxy.VarInt(req, "empty"); !errors.Is(err, muxy.ErrMissingVar) {
+		t.Errorf("expected ErrMissingVar; got %v", err)
+	}
+}
+
+func TestCurrentRoute(t *testing.T) {
+	var patterns []string
+	r := mpath.New()
+	r.Use(func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			patterns = append(patterns, muxy.CurrentPattern(req))
+			h.ServeHTTP(w, req)
+		})
+	})
+	r.Wrap(func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			if route := muxy.CurrentRoute(req); route != nil {
+				t.Errorf("unexpected route %q before matching", route.Pattern)
+			}
+			h.ServeHTTP(w, req)
+		})
+	})
+	g := r.Group("/users").Name("users:")
+	g.Route("/:id").Name("show").Get(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		route := muxy.CurrentRoute(req)
+		w.Write([]byte(route.Noun + " " + route.Router.URL("users:edit", "id", muxy.Var(req, "id"))))
+	}))
+	g.Route("/:id/edit").Name("edit")
+
+	if w := serve(r, "GET", "/users/42"); w.Body.String() != "users:show /users/42/edit" {
+		t.Errorf("unexpected body %q", w.Body.String())
+	}
+	serve(r, "GET", "/other")
+	if fmt.Sprint(patterns) != "[/users/:id]" {
+		t.Errorf("unexpected patterns %q", patterns)
+	}
+}
+
+func TestBind(t *testing.T) {
+	type params struct {
+		ID      int        `muxy:"id"`
+		Page    *uint8     `muxy:"page"`
+		Draft   bool       `muxy:"draft"`
+		Slug    string     `muxy:"slug"`
+		Day     time.Time  `muxy:"day,layout=2006-01-02"`
+		UUID    muxy.UUID  `muxy:"uuid"`
+		Missing string     `muxy:"missing"`
+		Ignored string     `muxy:"-"`
+		Other   *muxy.UUID `muxy:"other"`
+	}
+	var p params
+	var err error
+	r := mpath.New()
+	r.Route("/:id/:page/:draft/:slug/:day/:uuid/:other?").Handle(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		p = params{Missing: "x"}
+		err = muxy.Bind(req, &p)
+	}))
+
+	serve(r, "GET", "/42/3/true/hello/2016-05-01/f81d4fae-7dec-11d0-a765-00a0c91e6bf6")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.ID != 42 || p.Page == nil || *p.Page != 3 || !p.Draft || p.Slug != "hello" || p.Missing != "x" || p.Other != nil {
+		t.Errorf("unexpected values %+v", p)
+	}
+	if !p.Day.Equal(time.Date(2016, 5, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("unexpected day %v", p.Day)
+	}
+	if p.UUID.String() != "f81d4fae-7dec-11d0-a765-00a0c91e6bf6" {
+		t.Errorf("unexpected UUID %v", p.UUID)
+	}
+
+	serve(r, "GET", "/x/300/yes/hello/2016-05-01/f81d4fae-7dec-11d0-a765-00a0c91e6bf6/bad")
+	var berr *muxy.BindError
+	if !errors.As(err, &berr) {
+		t.Fatalf("expected *BindError; got %v", err)
+	}
+	var names []string
+	for _, e := range berr.Errors {
+		names = append(names, e.Name)
+	}
+	if fmt.Sprint(names) != "[id page draft other]" {
+		t.Errorf("unexpected errors for %v: %v", names, err)
+	}
+
+	if err := muxy.Bind(httptest.NewRequest("GET", "/", nil), p); err == nil {
+		t.Errorf("expected error binding to a non-pointer")
+	}
+	var bad struct {
+		Vars []string `muxy:"id"`
+	}
+	r = mpath.New()
+	r.Route("/:id").Handle(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		err = muxy.Bind(req, &bad)
+	}))
+	serve(r, "GET", "/1")
+	if err == nil || errors.As(err, &berr) {
+		t.Errorf("expected unsupported type error; got %v", err)
+	}
+}
+
+func TestMount(t *testing.T) {
+	tag := func(s string) func(http.Handler) http.Handler {
+		return func(h http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte(s + " "))
+				h.ServeHTTP(w, r)
+			})
+		}
+	}
+	src := mpath.New().Use(tag("s"))
+	src.Route("/users/:id").Name("user").Get(varsHandler("id"))
+	src.Route("/about").Get(varsHandler())
+	src.Route("/help").Get(varsHandler())
+	src.Group("/api").Use(tag("api")).Route("/status").Host("api.example.com").Get(varsHandler())
+	other := mregex.New().Use(tag("o"))
+	other.Route("/posts/{id:[0-9]+}").Name("post").Get(varsHandler("id"))
+
+	r := mpath.New().Use(tag("r"))
+	r.Group("/admin").Name("admin:").Use(tag("g")).Mount(src)
+	r.Group("/blog/").Name("blog:").Mount(other)
+	r.Route("/blog-index").Get(varsHandler())
+	r.Use(tag("r2"))
+
+	tests := []struct {
+		url  string
+		body string
+	}{
+		{"/admin/users/42", "r r2 g s 42"},
+		{"/admin/about", "r r2 g s "},
+		{"http://api.example.com/admin/api/status", "r r2 g s api "},
+		{"http://www.example.com/admin/api/status", "404 page not found\n"},
+		{"/blog/posts/7", "r r2 o 7"},
+		{"/blog/posts/x", "r r2 404 page not found\n"},
+		{"/blog-index", "r r2 "},
+	}
+	for _, v := range tests {
+		if w := serve(r, "GET", v.url); w.Body.String() != v.body {
+			t.Errorf("%s: expected body %q; got %q", v.url, v.body, w.Body.String())
+		}
+	}
+	for name, u := range map[string]string{
+		"admin:user": "/admin/users/42",
+		"blog:post":  "/blog/posts/42",
+		"post":       "",
+	} {
+		if v := r.URL(name, "id", "42"); v != u {
+			t.Errorf("%q: expected URL %q; got %q", name, u, v)
+		}
+	}
+	defer func() {
+		if recover() == nil {
+			t.Errorf("expected panic mounting on a pattern with variables")
+		}
+	}()
+	r.Group("/:tenant").Mount(other)
+}
+
+func TestMountPriority(t *testing.T) {
+	other := mregex.New()
+	other.Route("/{page}").Get(varsHandler("page"))
+
+	r := mpath.New()
+	r.Route("/admin/special").Get(varsHandler())
+	r.Group("/admin").Mount(other)
+	r.Route("/admin/late").Get(varsHandler())
+	r.Route("/main").Get(varsHandler())
+	r.Mount(mregex.New())
+
+	for url, body := range map[string]string{
+		"/admin/special": "",
+		"/admin/late":    "",
+		"/admin/other":   "other",
+		"/main":          "",
+	} {
+		if w := serve(r, "GET", url); w.Code != http.StatusOK || w.Body.String() != body {
+			t.Errorf("%s: expected 200 %q; got %d %q", url, body, w.Code, w.Body.String())
+		}
+	}
+}
+
+func TestMountConfig(t *testing.T) {
+	src := mpath.New(mpath.CaseInsensitive())
+	src.Route("/users").Name("users").Get(varsHandler())
+	r := mpath.New()
+	r.Route("/users").Get(varsHandler())
+	r.Group("/admin").Name("admin:").Mount(src)
+
+	for url, code := range map[string]int{
+		"/admin/users": http.StatusOK,
+		"/admin/USERS": http.StatusOK,
+		"/USERS":       http.StatusNotFound,
+	} {
+		if w := serve(r, "GET", url); w.Code != code {
+			t.Errorf("%s: expected code %d; got %d", url, code, w.Code)
+		}
+	}
+	if u := r.URL("admin:users"); u != "/admin/users" {
+		t.Errorf("expected URL %q; got %q", "/admin/users", u)
+	}
+}
+
+func TestMountRedirect(t *testing.T) {
+	src := mpath.New(mpath.RedirectCanonicalPath(http.StatusMovedPermanently), mpath.RedirectTrailingSlash(http.StatusMovedPermanently))
+	src.Route("/x/y").Get(varsHandler())
+	r := mregex.New()
+	r.Group("/api").Mount(src)
+
+	for _, v := range []string{"/api/x//y", "/api/x/y/", "/api/x/./y?q=1"} {
+		w := serve(r, "GET", v)
+		expected := "/api/x/y"
+		if strings.Contains(v, "?") {
+			expected += "?q=1"
+		}
+		if w.Code != http.StatusMovedPermanently || w.Header().Get("Location") != expected {
+			t.Errorf("%s: expected redirect to %q; got %d %q", v, expected, w.Code, w.Header().Get("Location"))
+		}
+	}
+	if w := serve(r, "GET", "/api/x/y"); w.Code != http.StatusOK {
+		t.Errorf("expected code 200; got %d", w.Code)
+	}
+}
+
+func TestHostSwitch(t *testing.T) {
+	site := mpath.New()
+	site.Route("/").Name("home").Get(varsHandler())
+	tenants := mpath.New().NotFound(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "no such page for "+muxy.Var(r, "tenant"), http.StatusNotFound)
+	}))
+	tenants.Route("/users/:id").Name("user").Schemes("https").Get(varsHandler("tenant", "id"))
+	legacy := mpath.New()
+	legacy.Route("/*").Get(varsHandler("*"))
+
+	hs := muxy.NewHostSwitch()
+	hs.Handle("{tenant}.example.com", tenants)
+	hs.Handle("*.example.org", legacy)
+	hs.Handle("www.example.com", site)
+	hs.Handle("{tenant:[a-z]+}.example.net", tenants)
+
+	tests := []struct {
+		url  string
+		code int
+		body string
+	}{
+		{"http://www.example.com/", http.StatusOK, ""},
+		{"https://acme.example.com/users/1", http.StatusOK, "acme 1"},
+		{"https://acme.example.com:8443/users/1", http.StatusOK, "acme 1"},
+		{"http://acme.example.com/x", http.StatusNotFound, "no such page for acme\n"},
+		{"http://a.b.EXAMPLE.org/x/y", http.StatusOK, "x/y"},
+		{"http://example.org/x", http.StatusNotFound, "404 page not found\n"},
+		{"https://acme.example.net:8443/users/1", http.StatusOK, "acme 1"},
+		{"http://example.net/", http.StatusNotFound, "404 page not found\n"},
+	}
+	for _, v := range tests {
+		w := serve(hs, "GET", v.url)
+		if w.Code != v.code || w.Body.String() != v.body {
+			t.Errorf("%s: expected %d %q; got %d %q", v.url, v.code, v.body, w.Code, w.Body.String())
+		}
+	}
+	hs.NotFound(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("unknown host"))
+	}))
+	if w := serve(hs, "GET", "http://example.net/"); w.Body.String() != "unknown host" {
+		t.Errorf("unexpected body %q", w.Body.String())
+	}
+
+	for _, v := range []struct {
+		host, name string
+		vars       []string
+		url        string
+	}{
+		{"www.example.com", "home", nil, "http://www.example.com/"},
+		{"acme.example.com", "user", []string{"id", "1"}, "https://acme.example.com/users/1"},
+	} {
+		if u, err := hs.BuildURL(v.host, v.name, v.vars...); u != v.url || err != nil {
+			t.Errorf("%s %s: expected %q; got %q, %v", v.host, v.name, v.url, u, err)
+		}
+	}
+	if _, err := hs.BuildURL("example.net", "home"); !errors.Is(err, muxy.ErrUnknownHost) {
+		t.Errorf("expected ErrUnknownHost; got %v", err)
+	}
+}
+
+func TestMetadata(t *testing.T) {
+	var auth []interface{}
+	r := mpath.New().Meta("auth", "user").Tag("api")
+	r.Use(func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			v, _ := muxy.MetaValue(req, "auth")
+			auth = append(auth, v)
+			h.ServeHTTP(w, req)
+		})
+	})
+	g := r.Group("/admin").Meta("auth", "admin").Tag("admin", "api")
+	g.Route("/users").Describe("Lists users.").Tag("users").Meta("limit", 10).Get(varsHandler())
+	old := r.Group("/v1").Deprecate()
+	old.Route("/users").Get(varsHandler())
+	r.Route("/public").Meta("auth", nil).Get(varsHandler())
+
+	md := g.Route("/x").Metadata()
+	if md.Values["auth"] != "admin" || fmt.Sprint(md.Tags) != "[api admin]" || md.Deprecated {
+		t.Errorf("unexpected group metadata %+v", md)
+	}
+	serve(r, "GET", "/admin/users")
+	serve(r, "GET", "/v1/users")
+	serve(r, "GET", "/public")
+	if fmt.Sprint(auth) != "[admin user <nil>]" {
+		t.Errorf("unexpected auth values %v", auth)
+	}
+
+	var got []string
+	r.Walk(func(route *muxy.Route, methods []string) error {
+		md := route.Metadata()
+		got = append(got, fmt.Sprintf("%s %q %v %v %v", route.Pattern, md.Description, md.Tags, md.Deprecated, md.Values["limit"]))
+		return nil
+	})
+	want := []string{
+		`/admin/users "Lists users." [api admin users] false 10`,
+		`/v1/users "" [api] true <nil>`,
+		`/public "" [api] false <nil>`,
+		`/admin/x "" [api admin] false <nil>`,
+	}
+	if strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Errorf("expected %q; got %q", want, got)
+	}
+	w := serve(muxy.RoutesHandler(r), "GET", "/routes")
+	if body := w.Body.String(); !strings.Contains(body, "api,admin,users") || !strings.Contains(body, "api,deprecated") {
+		t.Errorf("unexpected text output:\n%s", body)
+	}
+}
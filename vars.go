@@ -0,0 +1,180 @@
+package muxy
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Variable is the name of a route variable.
+type Variable string
+
+// WithVars returns a shallow copy of r with the given route variables,
+// passed as key/value pairs, added to its context. Matchers call it to
+// store the variables of the matched route; values set later take
+// precedence over earlier ones with the same name.
+func WithVars(r *http.Request, vars ...string) *http.Request {
+	if len(vars) == 0 {
+		return r
+	}
+	if prev := allVars(r); len(prev) != 0 {
+		vars = append(append(make([]string, 0, len(prev)+len(vars)), prev...), vars...)
+	}
+	return r.WithContext(context.WithValue(r.Context(), varsKey, vars))
+}
+
+// allVars returns the route variables in the request context as key/value
+// pairs.
+func allVars(r *http.Request) []string {
+	v, _ := r.Context().Value(varsKey).([]string)
+	return v
+}
+
+// Var returns the route variable with the given name from the request context.
+//
+// The returned value may be empty if the variable wasn't set; use LookupVar
+// to tell the difference.
+func Var(r *http.Request, name string) string {
+	v, _ := LookupVar(r, name)
+	return v
+}
+
+// LookupVar returns the route variable with the given name from the request
+// context, and whether it was set.
+func LookupVar(r *http.Request, name string) (string, bool) {
+	vars := allVars(r)
+	for i := len(vars) - 2; i >= 0; i -= 2 {
+		if vars[i] == name {
+			return vars[i+1], true
+		}
+	}
+	return "", false
+}
+
+// Vars returns the route variables from the request context, or nil if
+// there's none.
+func Vars(r *http.Request) map[string]string {
+	vars := allVars(r)
+	if len(vars) == 0 {
+		return nil
+	}
+	m := make(map[string]string, len(vars)/2)
+	for i := 0; i+1 < len(vars); i += 2 {
+		m[vars[i]] = vars[i+1]
+	}
+	return m
+}
+
+// -----------------------------------------------------------------------------
+
+// VarInt returns the route variable with the given name parsed as a decimal
+// int. Errors are of type *VarError; a missing variable results in one
+// wrapping ErrMissingVar.
+func VarInt(r *http.Request, name string) (int, error) {
+	v, err := lookupVar(r, name)
+	if err != nil {
+		return 0, err
+	}
+	i, err := strconv.Atoi(v)
+	if err != nil {
+		return 0, &VarError{Name: name, Err: err}
+	}
+	return i, nil
+}
+
+// VarInt64 returns the route variable with the given name parsed as a
+// decimal int64. Errors are reported as in VarInt.
+func VarInt64(r *http.Request, name string) (int64, error) {
+	v, err := lookupVar(r, name)
+	if err != nil {
+		return 0, err
+	}
+	i, err := strconv.ParseInt(v, 10, 64)
+	if err != nil {
+		return 0, &VarError{Name: name, Err: err}
+	}
+	return i, nil
+}
+
+// VarUUID returns the route variable with the given name parsed as a UUID
+// in its canonical form. Errors are reported as in VarInt.
+func VarUUID(r *http.Request, name string) (UUID, error) {
+	v, err := lookupVar(r, name)
+	if err != nil {
+		return UUID{}, err
+	}
+	u, err := ParseUUID(v)
+	if err != nil {
+		return UUID{}, &VarError{Name: name, Err: err}
+	}
+	return u, nil
+}
+
+// VarTime returns the route variable with the given name parsed with
+// time.Parse using the given layout. Errors are reported as in VarInt.
+func VarTime(r *http.Request, name, layout string) (time.Time, error) {
+	v, err := lookupVar(r, name)
+	if err != nil {
+		return time.Time{}, err
+	}
+	t, err := time.Parse(layout, v)
+	if err != nil {
+		return time.Time{}, &VarError{Name: name, Err: err}
+	}
+	return t, nil
+}
+
+// lookupVar returns the route variable with the given name, or an error if
+// it wasn't set.
+func lookupVar(r *http.Request, name string) (string, error) {
+	v, ok := LookupVar(r, name)
+	if !ok {
+		return "", &VarError{Name: name, Err: ErrMissingVar}
+	}
+	return v, nil
+}
+
+// -----------------------------------------------------------------------------
+
+// UUID is a universally unique identifier, as defined in RFC 4122.
+type UUID [16]byte
+
+// ParseUUID parses a UUID in its canonical form, as in
+// "f81d4fae-7dec-11d0-a765-00a0c91e6bf6". Hex digits can be upper or lower
+// case.
+func ParseUUID(s string) (UUID, error) {
+	var u UUID
+	if len(s) != 36 || s[8] != '-' || s[13] != '-' || s[18] != '-' || s[23] != '-' {
+		return u, fmt.Errorf("muxy: invalid UUID %q", s)
+	}
+	b := []byte(s[:8] + s[9:13] + s[14:18] + s[19:23] + s[24:])
+	if _, err := hex.Decode(u[:], b); err != nil {
+		return u, fmt.Errorf("muxy: invalid UUID %q", s)
+	}
+	return u, nil
+}
+
+// String returns the UUID in its canonical form, with lower case hex
+// digits.
+func (u UUID) String() string {
+	s := hex.EncodeToString(u[:])
+	return s[:8] + "-" + s[8:12] + "-" + s[12:16] + "-" + s[16:20] + "-" + s[20:]
+}
+
+// MarshalText implements encoding.TextMarshaler.
+func (u UUID) MarshalText() ([]byte, error) {
+	return []byte(u.String()), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler.
+func (u *UUID) UnmarshalText(b []byte) error {
+	v, err := ParseUUID(string(b))
+	if err != nil {
+		return err
+	}
+	*u = v
+	return nil
+}
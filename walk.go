@@ -0,0 +1,105 @@
+package muxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"text/tabwriter"
+)
+
+// WalkFunc is the type of the function called by Walk for each route.
+//
+// The methods are sorted, and a handler registered for any method is
+// reported as "*". The middleware wrapping the route is returned by
+// Route.Middleware. If the function returns an error, walking stops and
+// Walk returns the error.
+type WalkFunc func(route *Route, methods []string) error
+
+// Walk calls fn for all routes registered in the router, including those
+// registered through groups, in registration order. Routes of routers
+// mounted with a different matcher are not included; see Router.Mount.
+func (r *Router) Walk(fn WalkFunc) error {
+	for _, route := range r.Router.routes {
+		if err := fn(route, route.methods()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// methods returns the sorted methods of the route handlers.
+func (r *Route) methods() []string {
+	methods := make([]string, 0, len(r.Handlers))
+	for m := range r.Handlers {
+		if m == "" {
+			m = "*"
+		}
+		methods = append(methods, m)
+	}
+	sort.Strings(methods)
+	return methods
+}
+
+// Paths returns the path templates matched by the route, or nil if its
+// matcher doesn't implement PathMatcher.
+func (r *Route) Paths() [][]PathPart {
+	if m, ok := r.Router.Router.matcher.(PathMatcher); ok {
+		return m.Paths(r)
+	}
+	return nil
+}
+
+// -----------------------------------------------------------------------------
+
+// RoutesHandler returns a handler that renders the route table of the given
+// router, for debugging purposes. The table is rendered as JSON if the
+// request has a "format=json" query value or accepts "application/json",
+// and as plain text otherwise.
+func RoutesHandler(r *Router) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		var infos []routeInfo
+		r.Walk(func(route *Route, methods []string) error {
+			md := route.Metadata()
+			infos = append(infos, routeInfo{
+				Pattern:     route.Pattern,
+				Name:        route.Noun,
+				Methods:     methods,
+				Middleware:  len(route.Middleware()),
+				Description: md.Description,
+				Tags:        md.Tags,
+				Deprecated:  md.Deprecated,
+			})
+			return nil
+		})
+		if req.URL.Query().Get("format") == "json" ||
+			strings.Contains(req.Header.Get("Accept"), "application/json") {
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(infos)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+		fmt.Fprintln(tw, "METHODS\tPATTERN\tNAME\tMIDDLEWARE\tTAGS")
+		for _, i := range infos {
+			tags := strings.Join(i.Tags, ",")
+			if i.Deprecated {
+				tags = strings.TrimPrefix(tags+",deprecated", ",")
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%d\t%s\n", strings.Join(i.Methods, ","), i.Pattern, i.Name, i.Middleware, tags)
+		}
+		tw.Flush()
+	})
+}
+
+// routeInfo describes a route in the output of RoutesHandler.
+type routeInfo struct {
+	Pattern     string   `json:"pattern"`
+	Name        string   `json:"name,omitempty"`
+	Methods     []string `json:"methods"`
+	Middleware  int      `json:"middleware"`
+	Description string   `json:"description,omitempty"`
+	Tags        []string `json:"tags,omitempty"`
+	Deprecated  bool     `json:"deprecated,omitempty"`
+}